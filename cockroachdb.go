@@ -0,0 +1,49 @@
+package testdbs
+
+import (
+	"github.com/testcontainers/testcontainers-go"
+)
+
+const (
+	DBTypeCockroachdb = "cockroachdb"
+	cockroachdbImage  = "cockroachdb/cockroach:latest-v24.3"
+	// cockroachdbAdminDb is created by cockroachdb on startup
+	cockroachdbAdminDb = "defaultdb"
+)
+
+// NewCockroachdb returns a single node insecure cockroachdb TargetDb running in a container configured with the options,
+// cockroachdb uses the postgres driver and works like the postgres DB, except for template databases.
+func NewCockroachdb(opts ...Option) TargetDb {
+	c := &testDBPostgres{engine: DBTypeCockroachdb}
+	c.cfg.image = cockroachdbImage
+	c.cfg.user = "root"
+	for _, opt := range opts {
+		opt(&c.cfg)
+	}
+	return c
+}
+
+// CockroachdbVersions returns a cockroachdb TargetDb for every version, the image tag is the version
+// and DbType returns the engine followed by the version, e.g. "cockroachdb-v24.3.1".
+func CockroachdbVersions(versions ...string) []TargetDb {
+	dbs := make([]TargetDb, 0, len(versions))
+	for _, version := range versions {
+		dbs = append(dbs, NewCockroachdb(
+			WithImage("cockroachdb/cockroach:"+version),
+			WithLabel(DBTypeCockroachdb+"-"+version),
+		))
+	}
+	return dbs
+}
+
+// cockroachdbRequest returns the request to start a single node cockroachdb container,
+// in insecure mode the password is not checked. Like postgres it is ready once the admin database runs a query.
+func cockroachdbRequest(cfg containerConfig, dsn func(host, port string) string) testcontainers.ContainerRequest {
+	return testcontainers.ContainerRequest{
+		Image:        cfg.image,
+		ExposedPorts: []string{"26257/tcp"},
+		Env:          cfg.containerEnv(map[string]string{}),
+		Cmd:          append([]string{"start-single-node", "--insecure"}, cfg.cmd...),
+		WaitingFor:   waitForQuery("26257/tcp", "pgx", cfg.startupTimeout, dsn),
+	}
+}
@@ -0,0 +1,98 @@
+package testdbs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mysqlDriver "github.com/go-sql-driver/mysql"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	PostgresDsnEnv    = "TESTDBS_POSTGRES_DSN"
+	MysqlDsnEnv       = "TESTDBS_MYSQL_DSN"
+	MariadbDsnEnv     = "TESTDBS_MARIADB_DSN"
+	CockroachdbDsnEnv = "TESTDBS_COCKROACHDB_DSN"
+)
+
+// externalDb holds the state of a TargetDb connected to an externally provided server instead of a container,
+// databases are created with a unique prefix and only those are dropped on cleanup.
+type externalDb struct {
+	dsn     string
+	prefix  string
+	mu      sync.Mutex
+	created map[string]struct{}
+}
+
+func newExternalDb(dsn string) (*externalDb, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("unable to generate database prefix: %w", err)
+	}
+	return &externalDb{
+		dsn:     dsn,
+		prefix:  fmt.Sprintf("testdbs_%s_", hex.EncodeToString(b)),
+		created: map[string]struct{}{},
+	}, nil
+}
+
+// dbName returns the name of the database on the server, shortened to the identifier limit of postgres
+func (e *externalDb) dbName(name string) string {
+	name = e.prefix + name
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return name
+}
+
+// add records a database created by testdbs
+func (e *externalDb) add(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.created[name] = struct{}{}
+}
+
+// remove forgets a database once it was dropped
+func (e *externalDb) remove(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.created, name)
+}
+
+// names returns the databases created by testdbs that were not dropped yet
+func (e *externalDb) names() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	names := make([]string, 0, len(e.created))
+	for name := range e.created {
+		names = append(names, name)
+	}
+	return names
+}
+
+// postgresDsnWithDb returns the dsn connecting to the database name, both URL and keyword/value dsn are supported
+func postgresDsnWithDb(dsn, name string) (string, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", fmt.Errorf("invalid postgres dsn: %w", err)
+		}
+		u.Path = "/" + name
+		return u.String(), nil
+	}
+	// later keywords override earlier ones
+	return fmt.Sprintf("%s dbname=%s", dsn, name), nil
+}
+
+// mysqlDsnWithDb returns the dsn connecting to the database name, an empty name connects to no database
+func mysqlDsnWithDb(dsn, name string) (string, error) {
+	cfg, err := mysqlDriver.ParseDSN(dsn)
+	if err != nil {
+		return "", fmt.Errorf("invalid mysql dsn: %w", err)
+	}
+	cfg.DBName = name
+	cfg.ParseTime = true
+	return cfg.FormatDSN(), nil
+}
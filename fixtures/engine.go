@@ -0,0 +1,200 @@
+package fixtures
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// errChecksEnabled is returned by disableConstraints when the foreign key checks stay on,
+// the tables can still be filled in the order of their foreign keys unless they form a cycle.
+var errChecksEnabled = errors.New("the foreign key checks can't be disabled")
+
+// disableConstraints turns off the foreign key checks of the tables for the rest of the transaction,
+// the returned func turns them on again on the engines where the setting outlives the transaction.
+func disableConstraints(tx *gorm.DB, tables []string) (func() error, error) {
+	noop := func() error { return nil }
+	switch tx.Dialector.Name() {
+	case "postgres":
+		// only superusers can change the replication role, the savepoint keeps the transaction usable if it fails
+		err := tx.Transaction(func(tx *gorm.DB) error {
+			return tx.Exec("SET LOCAL session_replication_role = replica").Error
+		})
+		if err != nil {
+			return noop, fmt.Errorf("%w: %w", errChecksEnabled, err)
+		}
+		return noop, nil
+	case "mysql":
+		if err := tx.Exec("SET FOREIGN_KEY_CHECKS = 0").Error; err != nil {
+			return nil, fmt.Errorf("unable to disable foreign key checks: %w", err)
+		}
+		return func() error {
+			return tx.Exec("SET FOREIGN_KEY_CHECKS = 1").Error
+		}, nil
+	case "sqlite":
+		// foreign_keys can't be changed in a transaction, the checks are deferred until the commit instead
+		if err := tx.Exec("PRAGMA defer_foreign_keys = ON").Error; err != nil {
+			return nil, fmt.Errorf("unable to defer foreign key checks: %w", err)
+		}
+		return noop, nil
+	case "sqlserver":
+		// the checks are turned off per table, turning them on again with check validates the inserted rows
+		for _, table := range tables {
+			if err := tx.Exec("ALTER TABLE " + tx.Statement.Quote(table) + " NOCHECK CONSTRAINT ALL").Error; err != nil {
+				return nil, fmt.Errorf("unable to disable the constraints of %s: %w", table, err)
+			}
+		}
+		return func() error {
+			for _, table := range tables {
+				if err := tx.Exec("ALTER TABLE " + tx.Statement.Quote(table) + " WITH CHECK CHECK CONSTRAINT ALL").Error; err != nil {
+					return fmt.Errorf("unable to enable the constraints of %s: %w", table, err)
+				}
+			}
+			return nil
+		}, nil
+	}
+	return noop, nil
+}
+
+// identityInsert allows explicit values for the identity column of the table on sql server if the rows set it,
+// the rows are inserted as maps so the driver doesn't know the column. The returned func turns it off again
+// since only one table of a session can have it on.
+func identityInsert(tx *gorm.DB, table string, tableRows []map[string]any) (func() error, error) {
+	noop := func() error { return nil }
+	if tx.Dialector.Name() != "sqlserver" {
+		return noop, nil
+	}
+	var columns []string
+	err := tx.Raw("SELECT name FROM sys.identity_columns WHERE object_id = OBJECT_ID(?)", table).Scan(&columns).Error
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the identity column of %s: %w", table, err)
+	}
+	setsIdentity := len(columns) > 0 && slices.ContainsFunc(tableRows, func(row map[string]any) bool {
+		_, ok := row[columns[0]]
+		return ok
+	})
+	if !setsIdentity {
+		return noop, nil
+	}
+
+	quoted := tx.Statement.Quote(table)
+	if err := tx.Exec("SET IDENTITY_INSERT " + quoted + " ON").Error; err != nil {
+		return nil, fmt.Errorf("unable to enable identity insert on %s: %w", table, err)
+	}
+	return func() error {
+		return tx.Exec("SET IDENTITY_INSERT " + quoted + " OFF").Error
+	}, nil
+}
+
+// referencedTables returns the tables referenced by the foreign keys of table
+func referencedTables(tx *gorm.DB, table string) ([]string, error) {
+	var query string
+	switch tx.Dialector.Name() {
+	case "postgres":
+		query = `SELECT ccu.table_name FROM information_schema.table_constraints tc
+JOIN information_schema.constraint_column_usage ccu
+ON tc.constraint_name = ccu.constraint_name AND tc.table_schema = ccu.table_schema
+WHERE tc.constraint_type = 'FOREIGN KEY' AND tc.table_schema = current_schema() AND tc.table_name = ?`
+	case "mysql":
+		query = `SELECT REFERENCED_TABLE_NAME FROM information_schema.KEY_COLUMN_USAGE
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND REFERENCED_TABLE_NAME IS NOT NULL`
+	case "sqlite":
+		query = `SELECT "table" FROM pragma_foreign_key_list(?)`
+	case "sqlserver":
+		query = `SELECT OBJECT_NAME(referenced_object_id) FROM sys.foreign_keys WHERE parent_object_id = OBJECT_ID(?)`
+	default:
+		return nil, nil
+	}
+
+	var tables []string
+	if err := tx.Raw(query, table).Scan(&tables).Error; err != nil {
+		return nil, fmt.Errorf("unable to read the foreign keys of %s: %w", table, err)
+	}
+	return tables, nil
+}
+
+// insertOrder sorts the tables so that referenced tables are filled first, the tables in or depending on
+// a reference cycle are appended by name and also returned as cyclic, they rely on the disabled constraints.
+func insertOrder(tx *gorm.DB, data rows) (order, cyclic []string, err error) {
+	pending := make([]string, 0, len(data))
+	for table := range data {
+		pending = append(pending, table)
+	}
+	sort.Strings(pending)
+
+	deps := map[string][]string{}
+	for _, table := range pending {
+		refs, err := referencedTables(tx, table)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, ref := range refs {
+			// only the tables in the fixtures and no self references
+			if ref != table && slices.Contains(pending, ref) {
+				deps[table] = append(deps[table], ref)
+			}
+		}
+	}
+
+	order = make([]string, 0, len(pending))
+	for len(pending) > 0 {
+		idx := slices.IndexFunc(pending, func(table string) bool {
+			for _, dep := range deps[table] {
+				if !slices.Contains(order, dep) {
+					return false
+				}
+			}
+			return true
+		})
+		if idx == -1 {
+			return append(order, pending...), pending, nil
+		}
+		order = append(order, pending[idx])
+		pending = slices.Delete(pending, idx, idx+1)
+	}
+	return order, nil, nil
+}
+
+// timeColumns returns the columns of the table holding dates or times
+func timeColumns(tx *gorm.DB, table string) (map[string]bool, error) {
+	columnTypes, err := tx.Migrator().ColumnTypes(table)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the columns of %s: %w", table, err)
+	}
+	columns := map[string]bool{}
+	for _, c := range columnTypes {
+		typeName := strings.ToLower(c.DatabaseTypeName())
+		if strings.Contains(typeName, "time") || strings.Contains(typeName, "date") {
+			columns[c.Name()] = true
+		}
+	}
+	return columns, nil
+}
+
+// resetSequences sets the sequences of the postgres serial and identity columns after the highest inserted value,
+// the other engines move their auto increment counters on insert.
+func resetSequences(tx *gorm.DB, tables []string) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	for _, table := range tables {
+		var columns []string
+		err := tx.Raw(`SELECT column_name FROM information_schema.columns
+WHERE table_schema = current_schema() AND table_name = ? AND (column_default LIKE 'nextval(%' OR is_identity = 'YES')`,
+			table).Scan(&columns).Error
+		if err != nil {
+			return fmt.Errorf("unable to read the sequences of %s: %w", table, err)
+		}
+		for _, column := range columns {
+			query := fmt.Sprintf("SELECT setval(pg_get_serial_sequence(?, ?), COALESCE(MAX(%s), 0) + 1, false) FROM %s",
+				tx.Statement.Quote(column), tx.Statement.Quote(table))
+			if err := tx.Exec(query, tx.Statement.Quote(table), column).Error; err != nil {
+				return fmt.Errorf("unable to reset the sequence of %s.%s: %w", table, column, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+// Package fixtures loads table-keyed YAML or JSON files into a database, e.g. a connection returned
+// by Conn or ConnDbName of a testdbs.TargetDb.
+//
+// A fixture file maps table names to the rows to insert:
+//
+//	users:
+//	  - id: 1
+//	    name: alice
+//	    created_at: '{{ ago "24h" }}'
+//	posts:
+//	  - id: 1
+//	    user_id: 1
+//
+// The files are executed as text/template before being parsed, the functions now, ago and fromNow
+// return timestamps relative to the time of the load, durations accept the units of time.ParseDuration
+// and "d" for days. String values in RFC 3339 format of date and time columns are inserted as timestamps,
+// the strings of the other columns, e.g. text, are inserted as they are.
+package fixtures
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// rows holds the rows of every table of the fixtures
+type rows map[string][]map[string]any
+
+// Load inserts the rows of the fixture files into db, files ending in .json are parsed as JSON, the others as YAML.
+// The tables are filled in the order of their foreign keys in a single transaction.
+func Load(db *gorm.DB, files ...string) error {
+	return load(db, os.ReadFile, files)
+}
+
+// LoadFS is like Load but reads the files from fsys, e.g. an embed.FS
+func LoadFS(db *gorm.DB, fsys fs.FS, files ...string) error {
+	return load(db, func(name string) ([]byte, error) {
+		return fs.ReadFile(fsys, name)
+	}, files)
+}
+
+func load(db *gorm.DB, read func(name string) ([]byte, error), files []string) error {
+	now := time.Now()
+	data := rows{}
+	for _, file := range files {
+		content, err := read(file)
+		if err != nil {
+			return fmt.Errorf("unable to read fixture %s: %w", file, err)
+		}
+		fileRows, err := parse(file, content, now)
+		if err != nil {
+			return fmt.Errorf("unable to parse fixture %s: %w", file, err)
+		}
+		for table, r := range fileRows {
+			data[table] = append(data[table], r...)
+		}
+	}
+	return insert(db, data)
+}
+
+// parse executes the template of the file and decodes the rows
+func parse(file string, content []byte, now time.Time) (rows, error) {
+	tmpl, err := template.New(file).Option("missingkey=error").Funcs(funcs(now)).Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return nil, err
+	}
+
+	data := rows{}
+	if strings.EqualFold(filepath.Ext(file), ".json") {
+		dec := json.NewDecoder(&buf)
+		dec.UseNumber()
+		err = dec.Decode(&data)
+	} else {
+		err = yaml.Unmarshal(buf.Bytes(), &data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tableRows := range data {
+		for _, row := range tableRows {
+			for col, val := range row {
+				row[col] = convert(val)
+			}
+		}
+	}
+	return data, nil
+}
+
+// convert turns the decoded values into the types passed to the database driver
+func convert(val any) any {
+	switch v := val.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	}
+	return val
+}
+
+// convertTimes turns the strings in RFC 3339 format of the time columns into timestamps,
+// the strings of the other columns are inserted as they are.
+func convertTimes(tableRows []map[string]any, columns map[string]bool) {
+	for _, row := range tableRows {
+		for col, val := range row {
+			s, ok := val.(string)
+			if !ok || !columns[col] {
+				continue
+			}
+			if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				row[col] = t
+			}
+		}
+	}
+}
+
+// funcs returns the template functions, all the timestamps are relative to now
+func funcs(now time.Time) template.FuncMap {
+	return template.FuncMap{
+		"now": func() string {
+			return now.Format(time.RFC3339Nano)
+		},
+		"ago": func(duration string) (string, error) {
+			d, err := parseDuration(duration)
+			if err != nil {
+				return "", err
+			}
+			return now.Add(-d).Format(time.RFC3339Nano), nil
+		},
+		"fromNow": func(duration string) (string, error) {
+			d, err := parseDuration(duration)
+			if err != nil {
+				return "", err
+			}
+			return now.Add(d).Format(time.RFC3339Nano), nil
+		},
+	}
+}
+
+// parseDuration is like time.ParseDuration but also accepts days, e.g. "3d"
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// insert writes the rows in a transaction, foreign key checks are disabled where the engine allows it
+// and the tables are filled in the order of their foreign keys to support the other engines.
+func insert(db *gorm.DB, data rows) error {
+	return db.Transaction(func(tx *gorm.DB) (err error) {
+		enable, disableErr := disableConstraints(tx, slices.Sorted(maps.Keys(data)))
+		if disableErr != nil && !errors.Is(disableErr, errChecksEnabled) {
+			return disableErr
+		}
+		defer func() {
+			if eErr := enable(); eErr != nil && err == nil {
+				err = eErr
+			}
+		}()
+
+		tables, cyclic, err := insertOrder(tx, data)
+		if err != nil {
+			return err
+		}
+		if len(cyclic) > 0 && disableErr != nil {
+			return fmt.Errorf("the tables %s are in or depend on a reference cycle that can only be loaded "+
+				"with the foreign key checks disabled: %w", strings.Join(cyclic, ", "), disableErr)
+		}
+		for _, table := range tables {
+			columns, err := timeColumns(tx, table)
+			if err != nil {
+				return err
+			}
+			convertTimes(data[table], columns)
+
+			identityOff, err := identityInsert(tx, table, data[table])
+			if err != nil {
+				return err
+			}
+			for _, row := range data[table] {
+				if err := tx.Table(table).Create(row).Error; err != nil {
+					return fmt.Errorf("unable to insert fixture into %s: %w", table, err)
+				}
+			}
+			if err := identityOff(); err != nil {
+				return fmt.Errorf("unable to disable identity insert on %s: %w", table, err)
+			}
+		}
+		return resetSequences(tx, tables)
+	})
+}
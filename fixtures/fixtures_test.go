@@ -0,0 +1,137 @@
+package fixtures_test
+
+import (
+	"github.com/go-bumbu/testdbs"
+	"github.com/go-bumbu/testdbs/fixtures"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	testdbs.InitDBS()
+	code := m.Run()
+	err := testdbs.Clean()
+	if err != nil {
+		os.Exit(1)
+	}
+	os.Exit(code)
+}
+
+type User struct {
+	ID   uint `gorm:"primaryKey"`
+	Name string
+}
+
+type Post struct {
+	ID          uint `gorm:"primaryKey"`
+	UserID      uint
+	User        User
+	Title       string
+	PublishedAt time.Time
+}
+
+type Comment struct {
+	ID        uint `gorm:"primaryKey"`
+	PostID    uint
+	Post      Post
+	Text      string
+	CreatedAt time.Time
+}
+
+func TestLoad(t *testing.T) {
+	for _, dbt := range testdbs.DBs() {
+		t.Run(dbt.DbType(), func(t *testing.T) {
+			db := testdbs.ForTest(t, dbt)
+			err := db.AutoMigrate(&User{}, &Post{}, &Comment{})
+			if err != nil {
+				t.Fatalf("error in automigrate: %s", err)
+			}
+
+			err = fixtures.Load(db, "testdata/blog.yaml", "testdata/comments.json")
+			if err != nil {
+				t.Fatalf("unable to load fixtures: %v", err)
+			}
+
+			var posts []Post
+			if err := db.Order("id").Find(&posts).Error; err != nil {
+				t.Fatal(err)
+			}
+			if len(posts) != 2 {
+				t.Fatalf("expected 2 posts, got %d", len(posts))
+			}
+			wantPublished := time.Now().Add(-48 * time.Hour)
+			if diff := posts[0].PublishedAt.Sub(wantPublished).Abs(); diff > time.Minute {
+				t.Errorf("expected published at %v, got %v", wantPublished, posts[0].PublishedAt)
+			}
+			if !posts[1].PublishedAt.After(time.Now()) {
+				t.Errorf("expected published at in the future, got %v", posts[1].PublishedAt)
+			}
+
+			var comment Comment
+			if err := db.Preload("Post").First(&comment).Error; err != nil {
+				t.Fatal(err)
+			}
+			if comment.Post.Title != "first post" {
+				t.Errorf("expected the comment of the first post, got %q", comment.Post.Title)
+			}
+
+			// the auto increment continues after the fixtures
+			user := User{Name: "carol"}
+			if err := db.Create(&user).Error; err != nil {
+				t.Fatalf("unable to create user after the fixtures: %v", err)
+			}
+			if user.ID != 3 {
+				t.Errorf("expected id 3, got %d", user.ID)
+			}
+		})
+	}
+}
+
+func TestLoadFSErrors(t *testing.T) {
+	fsys := fstest.MapFS{
+		"unknown_func.yaml":  {Data: []byte(`users: [{name: '{{ yesterday }}'}]`)},
+		"bad_duration.yaml":  {Data: []byte(`users: [{name: '{{ ago "later" }}'}]`)},
+		"missing_table.yaml": {Data: []byte(`missing: [{name: alice}]`)},
+	}
+	dbt := testdbs.DBs()[0]
+	for name := range fsys {
+		t.Run(name, func(t *testing.T) {
+			db := testdbs.ForTest(t, dbt)
+			if err := db.AutoMigrate(&User{}); err != nil {
+				t.Fatalf("error in automigrate: %s", err)
+			}
+			err := fixtures.LoadFS(db, fsys, name)
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestLoadTimestampText(t *testing.T) {
+	fsys := fstest.MapFS{
+		"users.yaml": {Data: []byte(`users: [{id: 1, name: '2024-01-02T03:04:05Z'}]`)},
+	}
+	for _, dbt := range testdbs.DBs() {
+		t.Run(dbt.DbType(), func(t *testing.T) {
+			db := testdbs.ForTest(t, dbt)
+			if err := db.AutoMigrate(&User{}); err != nil {
+				t.Fatalf("error in automigrate: %s", err)
+			}
+			if err := fixtures.LoadFS(db, fsys, "users.yaml"); err != nil {
+				t.Fatalf("unable to load fixtures: %v", err)
+			}
+
+			// only the time columns get timestamps, text columns keep the string
+			var user User
+			if err := db.First(&user, 1).Error; err != nil {
+				t.Fatal(err)
+			}
+			if user.Name != "2024-01-02T03:04:05Z" {
+				t.Errorf("expected the name to be kept as text, got %q", user.Name)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+// Package tbtest provides a fake testing.TB for the tests of the helpers that report failures on a test.
+package tbtest
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+// TB is a testing.TB that records the errors and the logs instead of reporting them on the embedded TB,
+// the other methods, e.g. Name and Cleanup, are the ones of the embedded TB.
+// Like in the testing package Fatal and Fatalf stop the goroutine, use Run to call code that can fail fatally.
+type TB struct {
+	testing.TB
+	Errors []string
+	Logs   []string
+	// Failing makes Failed report a failed test even without recorded errors
+	Failing bool
+}
+
+// New returns a TB wrapping t
+func New(t testing.TB) *TB {
+	return &TB{TB: t}
+}
+
+func (tb *TB) Error(args ...any) {
+	tb.Errors = append(tb.Errors, fmt.Sprint(args...))
+}
+
+func (tb *TB) Errorf(format string, args ...any) {
+	tb.Errors = append(tb.Errors, fmt.Sprintf(format, args...))
+}
+
+func (tb *TB) Fatal(args ...any) {
+	tb.Error(args...)
+	runtime.Goexit()
+}
+
+func (tb *TB) Fatalf(format string, args ...any) {
+	tb.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+func (tb *TB) Log(args ...any) {
+	tb.Logs = append(tb.Logs, fmt.Sprint(args...))
+}
+
+func (tb *TB) Logf(format string, args ...any) {
+	tb.Logs = append(tb.Logs, fmt.Sprintf(format, args...))
+}
+
+func (tb *TB) Failed() bool {
+	return tb.Failing || len(tb.Errors) > 0
+}
+
+func (tb *TB) Helper() {}
+
+// Run calls f in a new goroutine and waits for it, so a Fatal in f only stops f
+func (tb *TB) Run(f func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	<-done
+}
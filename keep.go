@@ -0,0 +1,112 @@
+package testdbs
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/testcontainers/testcontainers-go"
+	tcexec "github.com/testcontainers/testcontainers-go/exec"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	KeepFailedEnv = "TESTDBS_KEEP_FAILED"
+	// keepDir is the directory, relative to the working dir of the tests, where the databases of failed tests are kept
+	keepDir = testDbDir + "_failed"
+)
+
+// Flag to keep the databases of failed tests
+var keepFailedFlag *bool
+
+func init() {
+	keepFailedFlag = flag.Bool("keepfailed", false, "keep the databases of failed tests in the dir "+keepDir)
+}
+
+// keepFailed returns true if the databases of failed tests are kept, set with the keepfailed flag or the env
+func keepFailed() bool {
+	if keepFailedFlag == nil {
+		panic("testing: keepFailed called before Init")
+	}
+	_, keepEnv := os.LookupEnv(KeepFailedEnv)
+	return keepEnv || *keepFailedFlag
+}
+
+// keeper is implemented by the DBs that can preserve a database for inspection,
+// keep saves the database to a file in dir and returns the path of the file.
+type keeper interface {
+	keep(name, dir string) (string, error)
+}
+
+// keepDb saves the database of a failed test and logs where it was saved, the errors are reported on the test
+func keepDb(t testing.TB, dbt TargetDb, name string) {
+	t.Helper()
+	k, ok := dbt.(keeper)
+	if !ok {
+		t.Logf("unable to keep database %s: %s does not support it", name, dbt.DbType())
+		return
+	}
+
+	dir, err := filepath.Abs(keepDir)
+	if err == nil {
+		err = os.MkdirAll(dir, 0750)
+	}
+	if err != nil {
+		t.Errorf("unable to create the dir to keep databases: %v", err)
+		return
+	}
+
+	path, err := k.keep(name, dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Logf("unable to keep database %s: %v", name, err)
+		return
+	}
+	if err != nil {
+		t.Errorf("unable to keep database %s on %s: %v", name, dbt.DbType(), err)
+		return
+	}
+	t.Logf("database %s of the failed test was kept in %s", name, path)
+}
+
+// dumpPath returns the path of the dump of the database name in dir
+func dumpPath(dir, name, dbType string) string {
+	return filepath.Join(dir, fmt.Sprintf("%s.%s.sql", name, normalizeDbName(dbType)))
+}
+
+// dumpFromContainer runs the dump command inside the container, the command writes the dump to containerPath
+// that is then copied to path.
+func dumpFromContainer(ctx context.Context, ctr testcontainers.Container, cmd []string, containerPath, path string) (err error) {
+	code, out, err := ctr.Exec(ctx, cmd, tcexec.Multiplexed())
+	if err != nil {
+		return fmt.Errorf("unable to run %s: %w", cmd[0], err)
+	}
+	if code != 0 {
+		msg, _ := io.ReadAll(out)
+		return fmt.Errorf("%s exited with code %d: %s", cmd[0], code, msg)
+	}
+	defer func() {
+		// the dump is not needed in the container anymore
+		_, _, _ = ctr.Exec(ctx, []string{"rm", "-f", containerPath})
+	}()
+
+	in, err := ctr.CopyFileFromContainer(ctx, containerPath)
+	if err != nil {
+		return fmt.Errorf("unable to copy the dump from the container: %w", err)
+	}
+	defer in.Close()
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cErr := f.Close(); cErr != nil && err == nil {
+			err = cErr
+		}
+	}()
+	_, err = io.Copy(f, in)
+	return err
+}
@@ -0,0 +1,159 @@
+package testdbs
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	// LogLevelEnv sets the level of the gorm logger: silent, error, warn or info
+	LogLevelEnv = "TESTDBS_LOG_LEVEL"
+	// SlowThresholdEnv sets the duration after which a statement is logged as slow, e.g. 200ms
+	SlowThresholdEnv = "TESTDBS_SLOW_THRESHOLD"
+	// LogColorEnv turns the colors of the gorm logger on or off, e.g. true or false
+	LogColorEnv = "TESTDBS_LOG_COLOR"
+	// LogPerTestEnv turns the per-test log of WithTestLog on or off, e.g. true or false
+	LogPerTestEnv = "TESTDBS_LOG_PER_TEST"
+)
+
+// logConfig holds the configuration of the gorm logger used by the DBs
+type logConfig struct {
+	level         logger.LogLevel
+	slowThreshold time.Duration
+	colorful      bool
+	perTest       bool
+}
+
+// LogOption configures the gorm logger passed to the DBs by InitDBS and InitCustomDbs
+type LogOption func(cfg *logConfig)
+
+// WithLogLevel sets the level of the gorm logger, the default is logger.Warn
+func WithLogLevel(level logger.LogLevel) LogOption {
+	return func(cfg *logConfig) {
+		cfg.level = level
+	}
+}
+
+// WithSlowThreshold sets the duration after which a statement is logged as slow, the default is one second
+func WithSlowThreshold(threshold time.Duration) LogOption {
+	return func(cfg *logConfig) {
+		cfg.slowThreshold = threshold
+	}
+}
+
+// WithColor enables the colors of the gorm logger
+func WithColor() LogOption {
+	return func(cfg *logConfig) {
+		cfg.colorful = true
+	}
+}
+
+// WithTestLog writes the statements run through the connections of ForTest and TxConn with t.Logf instead
+// of stdout, so they are only shown for failed tests or with -v
+func WithTestLog() LogOption {
+	return func(cfg *logConfig) {
+		cfg.perTest = true
+	}
+}
+
+// logCfg is the configuration set by InitCustomDbs, used for the per-test loggers
+var logCfg = defaultLogConfig()
+
+func defaultLogConfig() logConfig {
+	return logConfig{
+		level:         logger.Warn,
+		slowThreshold: time.Second,
+	}
+}
+
+// newLogConfig applies the options and then the envs, so the envs can change the configuration of a run
+func newLogConfig(opts ...LogOption) (logConfig, error) {
+	cfg := defaultLogConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if level := os.Getenv(LogLevelEnv); level != "" {
+		l, err := parseLogLevel(level)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.level = l
+	}
+	if threshold := os.Getenv(SlowThresholdEnv); threshold != "" {
+		d, err := time.ParseDuration(threshold)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid %s: %w", SlowThresholdEnv, err)
+		}
+		cfg.slowThreshold = d
+	}
+	if colorful := os.Getenv(LogColorEnv); colorful != "" {
+		b, err := strconv.ParseBool(colorful)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid %s: %w", LogColorEnv, err)
+		}
+		cfg.colorful = b
+	}
+	if perTest := os.Getenv(LogPerTestEnv); perTest != "" {
+		b, err := strconv.ParseBool(perTest)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid %s: %w", LogPerTestEnv, err)
+		}
+		cfg.perTest = b
+	}
+	return cfg, nil
+}
+
+func parseLogLevel(level string) (logger.LogLevel, error) {
+	switch strings.ToLower(level) {
+	case "silent":
+		return logger.Silent, nil
+	case "error":
+		return logger.Error, nil
+	case "warn":
+		return logger.Warn, nil
+	case "info":
+		return logger.Info, nil
+	}
+	return 0, fmt.Errorf("invalid %s %q, valid levels are: silent, error, warn, info", LogLevelEnv, level)
+}
+
+// newLogger returns a gorm logger writing to w with the configuration
+func (cfg logConfig) newLogger(w logger.Writer) logger.Interface {
+	return logger.New(w, logger.Config{
+		SlowThreshold:             cfg.slowThreshold,
+		LogLevel:                  cfg.level,
+		IgnoreRecordNotFoundError: true,
+		Colorful:                  cfg.colorful,
+	})
+}
+
+// stdoutLogger returns the logger shared by the connections of the DBs
+func (cfg logConfig) stdoutLogger() logger.Interface {
+	return cfg.newLogger(log.New(os.Stdout, "\r\n", log.LstdFlags))
+}
+
+// testWriter writes the log of gorm with t.Logf
+type testWriter struct {
+	t testing.TB
+}
+
+func (w testWriter) Printf(format string, args ...any) {
+	w.t.Helper()
+	w.t.Logf(format, args...)
+}
+
+// testLog returns a session of db logging through t.Logf if the per-test log is enabled, otherwise db
+func testLog(t testing.TB, db *gorm.DB) *gorm.DB {
+	if !logCfg.perTest {
+		return db
+	}
+	return db.Session(&gorm.Session{NewDB: true, Logger: logCfg.newLogger(testWriter{t: t})})
+}
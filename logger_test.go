@@ -0,0 +1,105 @@
+package testdbs
+
+import (
+	"github.com/go-bumbu/testdbs/internal/tbtest"
+	"github.com/google/go-cmp/cmp"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewLogConfig(t *testing.T) {
+	tcs := []struct {
+		name string
+		opts []LogOption
+		env  map[string]string
+		want logConfig
+	}{
+		{
+			name: "default",
+			want: logConfig{level: logger.Warn, slowThreshold: time.Second},
+		},
+		{
+			name: "options",
+			opts: []LogOption{WithLogLevel(logger.Info), WithSlowThreshold(time.Millisecond), WithColor(), WithTestLog()},
+			want: logConfig{level: logger.Info, slowThreshold: time.Millisecond, colorful: true, perTest: true},
+		},
+		{
+			name: "envOverridesOptions",
+			opts: []LogOption{WithLogLevel(logger.Info), WithSlowThreshold(time.Millisecond)},
+			env:  map[string]string{LogLevelEnv: "Silent", SlowThresholdEnv: "200ms", LogColorEnv: "true", LogPerTestEnv: "1"},
+			want: logConfig{level: logger.Silent, slowThreshold: 200 * time.Millisecond, colorful: true, perTest: true},
+		},
+		{
+			name: "envTurnsOff",
+			opts: []LogOption{WithColor(), WithTestLog()},
+			env:  map[string]string{LogColorEnv: "false", LogPerTestEnv: "0"},
+			want: logConfig{level: logger.Warn, slowThreshold: time.Second},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			got, err := newLogConfig(tc.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(logConfig{})); diff != "" {
+				t.Errorf("Mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("invalidEnv", func(t *testing.T) {
+		t.Setenv(LogLevelEnv, "verbose")
+		if _, err := newLogConfig(); err == nil {
+			t.Error("expected an error for an invalid log level")
+		}
+		t.Setenv(LogLevelEnv, "")
+		t.Setenv(SlowThresholdEnv, "soon")
+		if _, err := newLogConfig(); err == nil {
+			t.Error("expected an error for an invalid slow threshold")
+		}
+		t.Setenv(SlowThresholdEnv, "")
+		t.Setenv(LogColorEnv, "yes")
+		if _, err := newLogConfig(); err == nil {
+			t.Error("expected an error for an invalid color value")
+		}
+		t.Setenv(LogColorEnv, "")
+		t.Setenv(LogPerTestEnv, "on")
+		if _, err := newLogConfig(); err == nil {
+			t.Error("expected an error for an invalid per-test value")
+		}
+	})
+}
+
+func TestTestLog(t *testing.T) {
+	prev := logCfg
+	t.Cleanup(func() { logCfg = prev })
+
+	for _, dbt := range DBs() {
+		t.Run(dbt.DbType(), func(t *testing.T) {
+			tb := tbtest.New(t)
+
+			logCfg = logConfig{level: logger.Info, slowThreshold: time.Second}
+			db := ForTest(tb, dbt)
+			db.Exec("SELECT 1")
+			if len(tb.Logs) != 0 {
+				t.Errorf("expected no test logs without the per-test log, got %v", tb.Logs)
+			}
+
+			logCfg.perTest = true
+			for _, db := range []*gorm.DB{ForTest(tb, dbt), TxConn(tb, dbt)} {
+				tb.Logs = nil
+				db.Exec("SELECT 42")
+				if len(tb.Logs) != 1 || !strings.Contains(tb.Logs[0], "SELECT 42") {
+					t.Errorf("expected the statement in the test log, got %v", tb.Logs)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package testdbs
+
+const (
+	DBTypeMariadb = "mariadb"
+	mariadbImage  = "mariadb:11.4"
+)
+
+// NewMariadb returns a mariadb TargetDb running in a container configured with the options,
+// mariadb uses the mysql driver and supports the same features as the mysql DB.
+func NewMariadb(opts ...Option) TargetDb {
+	c := &testDBMysql{engine: DBTypeMariadb}
+	c.cfg.image = mariadbImage
+	for _, opt := range opts {
+		opt(&c.cfg)
+	}
+	return c
+}
+
+// MariadbVersions returns a mariadb TargetDb for every version, the image tag is the version
+// and DbType returns the engine followed by the version, e.g. "mariadb-10.11".
+func MariadbVersions(versions ...string) []TargetDb {
+	dbs := make([]TargetDb, 0, len(versions))
+	for _, version := range versions {
+		dbs = append(dbs, NewMariadb(
+			WithImage("mariadb:"+version),
+			WithLabel(DBTypeMariadb+"-"+version),
+		))
+	}
+	return dbs
+}
@@ -0,0 +1,75 @@
+package migrate
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"slices"
+	"strings"
+)
+
+// schema holds the tables of a database by name, it is compared with cmp.Diff
+type schema map[string]tableSchema
+
+type tableSchema struct {
+	Columns []columnSchema
+	Indexes []indexSchema
+}
+
+type columnSchema struct {
+	Name       string
+	Type       string
+	Nullable   bool
+	PrimaryKey bool
+}
+
+type indexSchema struct {
+	Name    string
+	Columns []string
+	Unique  bool
+}
+
+// inspect reads the tables, columns and indexes of the database, sorted by name so two schemas can be compared
+func inspect(db *gorm.DB) (schema, error) {
+	migrator := db.Migrator()
+	tables, err := migrator.GetTables()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list tables: %w", err)
+	}
+
+	s := schema{}
+	for _, table := range tables {
+		columnTypes, err := migrator.ColumnTypes(table)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the columns of %s: %w", table, err)
+		}
+		var ts tableSchema
+		for _, ct := range columnTypes {
+			c := columnSchema{Name: ct.Name(), Type: strings.ToLower(ct.DatabaseTypeName())}
+			if length, ok := ct.Length(); ok && length > 0 {
+				c.Type = fmt.Sprintf("%s(%d)", c.Type, length)
+			}
+			c.Nullable, _ = ct.Nullable()
+			c.PrimaryKey, _ = ct.PrimaryKey()
+			ts.Columns = append(ts.Columns, c)
+		}
+
+		indexes, err := migrator.GetIndexes(table)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the indexes of %s: %w", table, err)
+		}
+		for _, idx := range indexes {
+			i := indexSchema{Name: idx.Name(), Columns: idx.Columns()}
+			i.Unique, _ = idx.Unique()
+			ts.Indexes = append(ts.Indexes, i)
+		}
+
+		slices.SortFunc(ts.Columns, func(a, b columnSchema) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+		slices.SortFunc(ts.Indexes, func(a, b indexSchema) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+		s[table] = ts
+	}
+	return s, nil
+}
@@ -0,0 +1,302 @@
+// Package migrate applies numbered SQL migration files to a database, so that tests run on the schema
+// used in production instead of the one created by gorm AutoMigrate.
+//
+// The files are named <version>_<name>[.up|.down][.<engine>].sql, e.g. 0001_create_users.up.sql,
+// files without up or down are up migrations. A file with an engine suffix replaces the generic file
+// of the same version on that engine, the engine is matched against the DbType of the testdbs.TargetDb,
+// e.g. 0003_add_idx.up.mariadb.sql, or against the gorm dialect, e.g. 0003_add_idx.up.sqlite.sql.
+// Suffixes that are neither up, down nor an engine are an error, so a typo doesn't skip a migration,
+// the name can't contain dots since every part after a dot is a suffix.
+//
+// The files are split into statements on semicolons outside of quotes, comments and postgres dollar quoted
+// bodies, the applied versions are recorded in the table schema_migrations.
+package migrate
+
+import (
+	"cmp"
+	"fmt"
+	"github.com/go-bumbu/testdbs"
+	"gorm.io/gorm"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TableName is the table where the applied versions are recorded
+const TableName = "schema_migrations"
+
+// appliedMigration is a row of the tracking table
+type appliedMigration struct {
+	Version   int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (appliedMigration) TableName() string {
+	return TableName
+}
+
+// migration holds the up and down files of a version selected for the engine
+type migration struct {
+	version  int64
+	name     string
+	upFile   string
+	downFile string
+}
+
+// Up applies the migrations in dir that are not applied yet, dbType is the DbType of the TargetDb of db
+func Up(db *gorm.DB, dbType, dir string) error {
+	return UpFS(db, dbType, os.DirFS(dir))
+}
+
+// UpFS is like Up but reads the migrations from the root of fsys, e.g. an embed.FS
+func UpFS(db *gorm.DB, dbType string, fsys fs.FS) error {
+	migrations, err := readMigrations(fsys, dbType, db.Dialector.Name())
+	if err != nil {
+		return err
+	}
+	if err := createTable(db); err != nil {
+		return err
+	}
+
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if slices.Contains(applied, m.version) {
+			continue
+		}
+		if err := up(db, fsys, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Down rolls back the applied migrations of dir in reverse order, dbType is the DbType of the TargetDb of db
+func Down(db *gorm.DB, dbType, dir string) error {
+	return DownFS(db, dbType, os.DirFS(dir))
+}
+
+// DownFS is like Down but reads the migrations from the root of fsys, e.g. an embed.FS
+func DownFS(db *gorm.DB, dbType string, fsys fs.FS) error {
+	migrations, err := readMigrations(fsys, dbType, db.Dialector.Name())
+	if err != nil {
+		return err
+	}
+	if err := createTable(db); err != nil {
+		return err
+	}
+
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return err
+	}
+	for _, version := range slices.Backward(applied) {
+		idx := slices.IndexFunc(migrations, func(m migration) bool {
+			return m.version == version
+		})
+		if idx == -1 {
+			return fmt.Errorf("applied migration %d not found", version)
+		}
+		if err := down(db, fsys, migrations[idx]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createTable creates the tracking table unless it exists
+func createTable(db *gorm.DB) error {
+	if err := db.AutoMigrate(&appliedMigration{}); err != nil {
+		return fmt.Errorf("unable to create table %s: %w", TableName, err)
+	}
+	return nil
+}
+
+// up applies the migration and records it
+func up(db *gorm.DB, fsys fs.FS, m migration) error {
+	return apply(db, fsys, m.upFile, func(tx *gorm.DB) error {
+		return tx.Create(&appliedMigration{Version: m.version, Name: m.name, AppliedAt: time.Now()}).Error
+	})
+}
+
+// down rolls back the migration and removes its record
+func down(db *gorm.DB, fsys fs.FS, m migration) error {
+	if m.downFile == "" {
+		return fmt.Errorf("migration %s has no down migration", m.upFile)
+	}
+	return apply(db, fsys, m.downFile, func(tx *gorm.DB) error {
+		return tx.Delete(&appliedMigration{Version: m.version}).Error
+	})
+}
+
+// appliedVersions returns the versions recorded in the tracking table in ascending order
+func appliedVersions(db *gorm.DB) ([]int64, error) {
+	var versions []int64
+	err := db.Model(&appliedMigration{}).Order("version").Pluck("version", &versions).Error
+	if err != nil {
+		return nil, fmt.Errorf("unable to read table %s: %w", TableName, err)
+	}
+	return versions, nil
+}
+
+// apply runs the statements of the file and track in a single transaction
+func apply(db *gorm.DB, fsys fs.FS, file string, track func(tx *gorm.DB) error) error {
+	content, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return fmt.Errorf("unable to read migration %s: %w", file, err)
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range splitStatements(string(content)) {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return track(tx)
+	})
+	if err != nil {
+		return fmt.Errorf("migration %s failed: %w", file, err)
+	}
+	return nil
+}
+
+// migrationFile is a file of the migrations dir with the parts of its name
+type migrationFile struct {
+	name    string
+	version int64
+	title   string
+	down    bool
+	engine  string
+}
+
+// parseFileName returns the parts of the name of a migration file
+func parseFileName(name string) (migrationFile, error) {
+	f := migrationFile{name: name}
+	parts := strings.Split(strings.TrimSuffix(name, ".sql"), ".")
+	versionStr, title, _ := strings.Cut(parts[0], "_")
+	version, err := strconv.ParseInt(versionStr, 10, 64)
+	if err != nil {
+		return f, fmt.Errorf("migration %s does not start with a version number", name)
+	}
+	f.version = version
+	f.title = title
+
+	for _, part := range parts[1:] {
+		switch strings.ToLower(part) {
+		case "up":
+		case "down":
+			f.down = true
+		default:
+			if f.engine != "" {
+				return f, fmt.Errorf("migration %s has more than one engine suffix", name)
+			}
+			f.engine = strings.ToLower(part)
+		}
+	}
+	return f, nil
+}
+
+// engines are the engine suffixes of the file names besides the DbType of the run: the DbTypes of testdbs
+// and the gorm dialects
+var engines = []string{
+	testdbs.DBTypePostgres, testdbs.DBTypeCockroachdb, testdbs.DBTypeMysql, testdbs.DBTypeMariadb, testdbs.DBTypeSqlserver,
+	testdbs.DBTypeSqliteNOCgo, testdbs.DBTypeSqliteCgo, testdbs.DBTypeSqliteNoCgoMemory, testdbs.DBTypeSqliteCgoMemory,
+	"sqlite",
+}
+
+// knownEngine returns true if the suffix is an engine, the labels of WithLabel are accepted
+// when they start with an engine and a dash, e.g. postgres-16
+func knownEngine(suffix, dbType string) bool {
+	if suffix == strings.ToLower(dbType) {
+		return true
+	}
+	for _, engine := range engines {
+		engine = strings.ToLower(engine)
+		if suffix == engine || strings.HasPrefix(suffix, engine+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// readMigrations returns the migrations of fsys sorted by version, for every version the file of
+// the dbType is preferred over the file of the dialect and that one over the generic file.
+func readMigrations(fsys fs.FS, dbType, dialect string) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read migrations: %w", err)
+	}
+
+	// priority of the engine suffixes, lower is preferred
+	priority := func(engine string) int {
+		switch {
+		case engine == "":
+			return 2
+		case engine == strings.ToLower(dbType):
+			return 0
+		case engine == strings.ToLower(dialect):
+			return 1
+		}
+		return -1
+	}
+
+	type selected struct {
+		file     migrationFile
+		priority int
+	}
+	ups := map[int64]selected{}
+	downs := map[int64]selected{}
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+		f, err := parseFileName(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if f.engine != "" && !knownEngine(f.engine, dbType) {
+			return nil, fmt.Errorf("migration %s has the unknown suffix %q, expected up, down or an engine, names can't contain dots",
+				f.name, f.engine)
+		}
+		p := priority(f.engine)
+		if p == -1 {
+			// a variant for another engine
+			continue
+		}
+		files := ups
+		if f.down {
+			files = downs
+		}
+		current, exists := files[f.version]
+		if exists && current.priority == p {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", current.file.name, f.name)
+		}
+		if !exists || p < current.priority {
+			files[f.version] = selected{file: f, priority: p}
+		}
+	}
+
+	migrations := make([]migration, 0, len(ups))
+	for version, up := range ups {
+		m := migration{version: version, name: up.file.title, upFile: up.file.name}
+		if down, ok := downs[version]; ok {
+			m.downFile = down.file.name
+		}
+		migrations = append(migrations, m)
+	}
+	for version, down := range downs {
+		if _, ok := ups[version]; !ok {
+			return nil, fmt.Errorf("down migration %s has no up migration", down.file.name)
+		}
+	}
+	slices.SortFunc(migrations, func(a, b migration) int {
+		return cmp.Compare(a.version, b.version)
+	})
+	return migrations, nil
+}
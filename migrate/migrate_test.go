@@ -0,0 +1,187 @@
+package migrate_test
+
+import (
+	"github.com/go-bumbu/testdbs"
+	"github.com/go-bumbu/testdbs/internal/tbtest"
+	"github.com/go-bumbu/testdbs/migrate"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMain(m *testing.M) {
+	testdbs.InitDBS()
+	code := m.Run()
+	err := testdbs.Clean()
+	if err != nil {
+		os.Exit(1)
+	}
+	os.Exit(code)
+}
+
+func TestUp(t *testing.T) {
+	for _, dbt := range testdbs.DBs() {
+		t.Run(dbt.DbType(), func(t *testing.T) {
+			db := testdbs.ForTest(t, dbt)
+			err := migrate.Up(db, dbt.DbType(), "testdata/migrations")
+			if err != nil {
+				t.Fatalf("unable to apply migrations: %v", err)
+			}
+
+			for _, table := range []string{"users", "posts", "engine_info", migrate.TableName} {
+				if !db.Migrator().HasTable(table) {
+					t.Errorf("expected table %s to exist", table)
+				}
+			}
+
+			var name string
+			if err := db.Table("users").Where("id = ?", 1).Pluck("name", &name).Error; err != nil {
+				t.Fatal(err)
+			}
+			if name != "semi;colon" {
+				t.Errorf("expected name %q, got %q", "semi;colon", name)
+			}
+
+			want := "generic"
+			if db.Dialector.Name() == "sqlite" {
+				want = "sqlite"
+			}
+			var engine string
+			if err := db.Table("engine_info").Pluck("name", &engine).Error; err != nil {
+				t.Fatal(err)
+			}
+			if engine != want {
+				t.Errorf("expected the %s variant of the migration, got %s", want, engine)
+			}
+
+			// applied migrations are skipped
+			err = migrate.Up(db, dbt.DbType(), "testdata/migrations")
+			if err != nil {
+				t.Fatalf("unable to apply migrations again: %v", err)
+			}
+			var count int64
+			if err := db.Table(migrate.TableName).Count(&count).Error; err != nil {
+				t.Fatal(err)
+			}
+			if count != 3 {
+				t.Errorf("expected 3 applied migrations, got %d", count)
+			}
+		})
+	}
+}
+
+func TestUpErrors(t *testing.T) {
+	tcs := map[string]fstest.MapFS{
+		"duplicatedVersion": {
+			"0001_a.up.sql": {Data: []byte("CREATE TABLE a (id INTEGER)")},
+			"0001_b.up.sql": {Data: []byte("CREATE TABLE b (id INTEGER)")},
+		},
+		"missingVersion": {
+			"create_a.up.sql": {Data: []byte("CREATE TABLE a (id INTEGER)")},
+		},
+		"downWithoutUp": {
+			"0001_a.down.sql": {Data: []byte("DROP TABLE a")},
+		},
+		"unknownSuffix": {
+			"0001_a.up.sql":   {Data: []byte("CREATE TABLE a (id INTEGER)")},
+			"0002_b.dwon.sql": {Data: []byte("DROP TABLE a")},
+		},
+		"dotInName": {
+			"0001_add.idx.up.sql": {Data: []byte("CREATE TABLE a (id INTEGER)")},
+		},
+		"invalidSql": {
+			"0001_a.up.sql": {Data: []byte("CREATE TABLE")},
+		},
+	}
+	dbt := testdbs.DBs()[0]
+	for name, fsys := range tcs {
+		t.Run(name, func(t *testing.T) {
+			db := testdbs.ForTest(t, dbt)
+			err := migrate.UpFS(db, dbt.DbType(), fsys)
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestDown(t *testing.T) {
+	for _, dbt := range testdbs.DBs() {
+		t.Run(dbt.DbType(), func(t *testing.T) {
+			db := testdbs.ForTest(t, dbt)
+			if err := migrate.Up(db, dbt.DbType(), "testdata/migrations"); err != nil {
+				t.Fatalf("unable to apply migrations: %v", err)
+			}
+			if err := migrate.Down(db, dbt.DbType(), "testdata/migrations"); err != nil {
+				t.Fatalf("unable to roll back migrations: %v", err)
+			}
+
+			tables, err := db.Migrator().GetTables()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tables) != 1 || tables[0] != migrate.TableName {
+				t.Errorf("expected only the table %s, got %v", migrate.TableName, tables)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, dbt := range testdbs.DBs() {
+		t.Run(dbt.DbType(), func(t *testing.T) {
+			migrate.RoundTrip(t, dbt, "testdata/migrations")
+		})
+	}
+}
+
+func TestRoundTripFailures(t *testing.T) {
+	tcs := []struct {
+		name string
+		fsys fstest.MapFS
+		want string
+	}{
+		{
+			name: "downKeepsTable",
+			fsys: fstest.MapFS{
+				"0001_a.up.sql":   {Data: []byte("CREATE TABLE a (id INTEGER)")},
+				"0001_a.down.sql": {Data: []byte("SELECT 1")},
+				"0002_b.up.sql":   {Data: []byte("CREATE TABLE b (id INTEGER)")},
+				"0002_b.down.sql": {Data: []byte("DROP TABLE b")},
+			},
+			want: "0001_a.down.sql does not restore the schema",
+		},
+		{
+			name: "missingDown",
+			fsys: fstest.MapFS{
+				"0001_a.up.sql": {Data: []byte("CREATE TABLE a (id INTEGER)")},
+			},
+			want: "0001_a.up.sql has no down migration",
+		},
+		{
+			name: "downFails",
+			fsys: fstest.MapFS{
+				"0001_a.up.sql":   {Data: []byte("CREATE TABLE a (id INTEGER)")},
+				"0001_a.down.sql": {Data: []byte("DROP TABLE missing")},
+			},
+			want: "0001_a.down.sql failed",
+		},
+	}
+	dbt := testdbs.DBs()[0]
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			tb := tbtest.New(t)
+			tb.Run(func() {
+				migrate.RoundTripFS(tb, dbt, tc.fsys)
+			})
+
+			if len(tb.Errors) != 1 {
+				t.Fatalf("expected one failure, got %v", tb.Errors)
+			}
+			if !strings.Contains(tb.Errors[0], tc.want) {
+				t.Errorf("expected the failure to contain %q, got: %s", tc.want, tb.Errors[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package migrate
+
+import (
+	"github.com/go-bumbu/testdbs"
+	"github.com/google/go-cmp/cmp"
+	"io/fs"
+	"os"
+	"slices"
+	"testing"
+)
+
+// RoundTrip checks the down migrations of dir on a database of the test: the migrations are applied one by one,
+// rolled back in reverse order and applied again. The test fails if a migration errors, if a down migration
+// does not restore the schema from before its up migration, or if the schema after applying the migrations
+// again differs from the first pass.
+func RoundTrip(t testing.TB, dbt testdbs.TargetDb, dir string) {
+	t.Helper()
+	RoundTripFS(t, dbt, os.DirFS(dir))
+}
+
+// RoundTripFS is like RoundTrip but reads the migrations from the root of fsys, e.g. an embed.FS
+func RoundTripFS(t testing.TB, dbt testdbs.TargetDb, fsys fs.FS) {
+	t.Helper()
+	db := testdbs.ForTest(t, dbt)
+	migrations, err := readMigrations(fsys, dbt.DbType(), db.Dialector.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := createTable(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// schemas[i] is the schema before applying migrations[i]
+	schemas := make([]schema, 0, len(migrations)+1)
+	inspectDb := func() schema {
+		t.Helper()
+		s, err := inspect(db)
+		if err != nil {
+			t.Fatalf("unable to inspect the schema on %s: %v", dbt.DbType(), err)
+		}
+		return s
+	}
+
+	schemas = append(schemas, inspectDb())
+	for _, m := range migrations {
+		if err := up(db, fsys, m); err != nil {
+			t.Fatal(err)
+		}
+		schemas = append(schemas, inspectDb())
+	}
+
+	for i, m := range slices.Backward(migrations) {
+		if err := down(db, fsys, m); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(schemas[i], inspectDb()); diff != "" {
+			t.Fatalf("down migration %s does not restore the schema (-before up +after down):\n%s", m.downFile, diff)
+		}
+	}
+
+	for _, m := range migrations {
+		if err := up(db, fsys, m); err != nil {
+			t.Fatalf("unable to apply the migrations again: %v", err)
+		}
+	}
+	if diff := cmp.Diff(schemas[len(migrations)], inspectDb()); diff != "" {
+		t.Errorf("the schema after applying the migrations again differs (-first +second):\n%s", diff)
+	}
+}
@@ -0,0 +1,107 @@
+package migrate
+
+import (
+	"strings"
+)
+
+// splitStatements splits the content of a migration file into statements on the semicolons that are not
+// in quotes, comments or postgres dollar quoted bodies, e.g. $$ ... $$ or $body$ ... $body$.
+// Empty statements, only whitespace and comments, are dropped.
+func splitStatements(content string) []string {
+	var statements []string
+	var current strings.Builder
+	// hasCode is true once the current statement contains something that is not whitespace or a comment
+	hasCode := false
+
+	flush := func() {
+		if hasCode {
+			statements = append(statements, strings.TrimSpace(current.String()))
+		}
+		current.Reset()
+		hasCode = false
+	}
+
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case c == '-' && strings.HasPrefix(content[i:], "--"):
+			end := strings.IndexByte(content[i:], '\n')
+			if end == -1 {
+				end = len(content) - i
+			}
+			current.WriteString(content[i : i+end])
+			i += end - 1
+		case c == '/' && strings.HasPrefix(content[i:], "/*"):
+			end := strings.Index(content[i+2:], "*/")
+			if end == -1 {
+				end = len(content) - i
+			} else {
+				end += 4
+			}
+			current.WriteString(content[i : i+end])
+			i += end - 1
+		case c == '\'' || c == '"' || c == '`':
+			end := quoteEnd(content, i, c)
+			current.WriteString(content[i:end])
+			hasCode = true
+			i = end - 1
+		case c == '$':
+			tag, ok := dollarTag(content[i:])
+			if !ok {
+				current.WriteByte(c)
+				hasCode = true
+				continue
+			}
+			end := strings.Index(content[i+len(tag):], tag)
+			if end == -1 {
+				end = len(content) - i
+			} else {
+				end += 2 * len(tag)
+			}
+			current.WriteString(content[i : i+end])
+			hasCode = true
+			i += end - 1
+		case c == ';':
+			flush()
+		default:
+			current.WriteByte(c)
+			if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+				hasCode = true
+			}
+		}
+	}
+	flush()
+	return statements
+}
+
+// quoteEnd returns the index after the quote closing the one at start, doubled quotes are escaped quotes
+func quoteEnd(content string, start int, quote byte) int {
+	for i := start + 1; i < len(content); i++ {
+		if content[i] != quote {
+			continue
+		}
+		if i+1 < len(content) && content[i+1] == quote {
+			i++
+			continue
+		}
+		return i + 1
+	}
+	return len(content)
+}
+
+// dollarTag returns the dollar quote tag at the start of s, e.g. $$ or $body$,
+// placeholders like $1 are not tags since a tag can't start with a digit.
+func dollarTag(s string) (string, bool) {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if c == '$' {
+			return s[:i+1], true
+		}
+		isLetter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		isDigit := c >= '0' && c <= '9'
+		if !isLetter && !(isDigit && i > 1) {
+			return "", false
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,8 @@
+-- posts belong to a user; the semicolons in comments and strings don't end a statement
+CREATE TABLE posts (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER REFERENCES users (id),
+    title VARCHAR(200)
+);
+/* the first user; used by the tests */
+INSERT INTO users (id, name) VALUES (1, 'semi;colon');
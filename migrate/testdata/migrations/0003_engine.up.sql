@@ -0,0 +1,2 @@
+CREATE TABLE engine_info (name VARCHAR(50));
+INSERT INTO engine_info (name) VALUES ('generic');
@@ -3,13 +3,14 @@ package testdbs
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/hashicorp/go-multierror"
 	"github.com/testcontainers/testcontainers-go"
-	"github.com/testcontainers/testcontainers-go/wait"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
+	"os"
 	"sync"
 	"time"
 )
@@ -19,16 +20,36 @@ const (
 )
 
 type testDBMysql struct {
-	once   sync.Once
-	logger logger.Interface
-	host   string
-	port   string
-	pool   map[string]*gorm.DB
-	clean  func()
+	// engine is returned by DbType, it is empty for mysql
+	engine   string
+	cfg      containerConfig
+	once     sync.Once
+	initErr  error
+	logger   logger.Interface
+	host     string
+	port     string
+	pool     connPool
+	clean    func() error
+	external *externalDb
+	// container is nil on external servers
+	container testcontainers.Container
 }
 
+// Close closes the connection to the database, the database is also dropped when configured with WithDropOnClose
 func (c *testDBMysql) Close(name string) error {
-	db, exists := c.pool[name]
+	name = normalizeDbName(name)
+	if err := c.closeConn(name); err != nil {
+		return err
+	}
+	if c.cfg.dropOnClose {
+		return c.dropDatabase(c.dbName(name))
+	}
+	return nil
+}
+
+// closeConn closes the connection to the database keeping the database
+func (c *testDBMysql) closeConn(name string) error {
+	db, exists := c.pool.remove(name)
 	if !exists {
 		return fmt.Errorf("db connection with name %s not found", name)
 	}
@@ -40,121 +61,353 @@ func (c *testDBMysql) Close(name string) error {
 }
 
 func (c *testDBMysql) CloseAll() error {
-	defer c.clean()
 	var merr error
-	for name, _ := range c.pool {
-		err := c.Close(name)
+	for _, name := range c.pool.names() {
+		err := c.closeConn(name)
 		if err != nil {
 			merr = multierror.Append(merr, err)
 		}
 	}
+	// only drop the databases created by testdbs on external servers
+	if c.external != nil {
+		for _, name := range c.external.names() {
+			if err := c.dropDatabase(name); err != nil {
+				merr = multierror.Append(merr, err)
+			}
+		}
+	}
+	if c.clean != nil {
+		if err := c.clean(); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
 	return merr
 }
 
 func (c *testDBMysql) DbType() string {
+	if c.cfg.label != "" {
+		return c.cfg.label
+	}
+	if c.engine != "" {
+		return c.engine
+	}
 	return DBTypeMysql
 }
 
 const (
 	mysqlPassword = "password"
 	mysqlUser     = "testuser"
+	mysqlImage    = "mysql:8.0"
 )
 
+// NewMysql returns a mysql TargetDb running in a container configured with the options
+func NewMysql(opts ...Option) TargetDb {
+	c := &testDBMysql{}
+	for _, opt := range opts {
+		opt(&c.cfg)
+	}
+	return c
+}
+
+// MysqlVersions returns a mysql TargetDb for every version, the image tag is the version
+// and DbType returns the engine followed by the version, e.g. "mysql-8.4".
+func MysqlVersions(versions ...string) []TargetDb {
+	dbs := make([]TargetDb, 0, len(versions))
+	for _, version := range versions {
+		dbs = append(dbs, NewMysql(
+			WithImage("mysql:"+version),
+			WithLabel(DBTypeMysql+"-"+version),
+		))
+	}
+	return dbs
+}
+
 func (c *testDBMysql) Init(logger logger.Interface) {
-	c.logger = logger
-	c.pool = map[string]*gorm.DB{}
+	if err := c.InitE(logger); err != nil {
+		panic(err)
+	}
+}
 
+func (c *testDBMysql) InitE(logger logger.Interface) error {
+	c.logger = logger
 	c.once.Do(func() {
-		ctx := context.Background()
-
-		req := testcontainers.ContainerRequest{
-			Image:        "mysql:8.0",
-			ExposedPorts: []string{"3306/tcp"},
-			Env: map[string]string{
-				"MYSQL_ROOT_PASSWORD": mysqlPassword,
-				"MYSQL_DATABASE":      defaultDbName,
-				"MYSQL_USER":          mysqlUser,
-				"MYSQL_PASSWORD":      mysqlPassword,
-			},
-			WaitingFor: wait.ForListeningPort("3306/tcp").WithStartupTimeout(60 * time.Second),
-		}
-		mysqlContainer, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
-			ContainerRequest: req,
-			Started:          true,
+		c.cfg.withDefaults(containerConfig{
+			image:          mysqlImage,
+			startupTimeout: 60 * time.Second,
+			dbName:         defaultDbName,
+			user:           mysqlUser,
+			password:       mysqlPassword,
 		})
-		if err != nil {
-			panic(fmt.Sprintf("failed to start MySQL container: %v", err))
-		}
+		c.initErr = c.start()
+	})
+	return c.initErr
+}
 
-		host, err := mysqlContainer.Host(ctx)
+// start runs the mysql container and opens the connection to the default DB,
+// if any step fails the container is terminated again.
+func (c *testDBMysql) start() (err error) {
+	if dsn := os.Getenv(c.dsnEnv()); dsn != "" {
+		return c.startExternal(dsn)
+	}
+	if sharedContainers() {
+		return c.startShared()
+	}
+	ctx := context.Background()
+
+	mysqlContainer, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: c.containerRequest(),
+		Started:          true,
+	})
+	cleanFn := func() error {
+		if err := testcontainers.TerminateContainer(mysqlContainer); err != nil {
+			return fmt.Errorf("failed to terminate MySQL container: %w", err)
+		}
+		return nil
+	}
+	defer func() {
 		if err != nil {
-			panic(fmt.Sprintf("failed to get MySQL container host: %v", err))
+			if cErr := cleanFn(); cErr != nil {
+				err = multierror.Append(err, cErr)
+			}
 		}
-		c.host = host
+	}()
+	if err != nil {
+		return fmt.Errorf("%w: failed to start MySQL container: %w", ErrContainerStart, err)
+	}
 
-		port, err := mysqlContainer.MappedPort(ctx, "3306")
-		if err != nil {
-			panic(fmt.Sprintf("failed to get MySQL container port: %v", err))
+	host, err := mysqlContainer.Host(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: failed to get MySQL container host: %w", ErrContainerStart, err)
+	}
+	c.host = host
+
+	port, err := mysqlContainer.MappedPort(ctx, "3306")
+	if err != nil {
+		return fmt.Errorf("%w: failed to get MySQL container port: %w", ErrContainerStart, err)
+	}
+	c.port = port.Port()
+
+	db, err := c.open(c.cfg.dbName)
+	if err != nil {
+		return err
+	}
+	c.clean = cleanFn
+	c.container = mysqlContainer
+	c.pool.set(c.cfg.dbName, db)
+	return nil
+}
+
+// dsnEnv returns the env holding the dsn of an external server
+func (c *testDBMysql) dsnEnv() string {
+	if c.engine == DBTypeMariadb {
+		return MariadbDsnEnv
+	}
+	return MysqlDsnEnv
+}
+
+// containerRequest returns the request to start the mysql container
+func (c *testDBMysql) containerRequest() testcontainers.ContainerRequest {
+	return testcontainers.ContainerRequest{
+		Image:        c.cfg.image,
+		ExposedPorts: []string{"3306/tcp"},
+		Env: c.cfg.containerEnv(map[string]string{
+			"MYSQL_ROOT_PASSWORD": c.cfg.password,
+			"MYSQL_DATABASE":      c.cfg.dbName,
+			"MYSQL_USER":          c.cfg.user,
+			"MYSQL_PASSWORD":      c.cfg.password,
+		}),
+		Cmd: c.cfg.cmd,
+		WaitingFor: waitForQuery("3306/tcp", "mysql", c.cfg.startupTimeout, func(host, port string) string {
+			return c.dsn(host, port, c.cfg.dbName)
+		}),
+	}
+}
+
+// startShared attaches to the mysql container shared with the test binaries of other packages,
+// like on external servers the databases are created with a unique prefix so that packages don't interfere.
+func (c *testDBMysql) startShared() error {
+	ctx := context.Background()
+	shared, err := startSharedContainer(ctx, c.containerRequest())
+	if err != nil {
+		return err
+	}
+	host, port, err := shared.address(ctx, "3306")
+	if err == nil {
+		dsn := fmt.Sprintf("root:%s@tcp(%s:%s)/", c.cfg.password, host, port)
+		err = c.startExternal(dsn)
+	}
+	if err != nil {
+		if rErr := shared.release(); rErr != nil {
+			err = multierror.Append(err, rErr)
 		}
-		c.port = port.Port()
+		return err
+	}
+	c.clean = shared.release
+	c.container = shared.container
+	return nil
+}
 
-		dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local", mysqlUser, mysqlPassword, host, port.Port(), defaultDbName)
-		db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
-			Logger: logger,
-		})
+// startExternal uses the server of the dsn instead of starting a container, the default DB
+// is created like any other database so that the existing data is not modified.
+func (c *testDBMysql) startExternal(dsn string) error {
+	external, err := newExternalDb(dsn)
+	if err != nil {
+		return err
+	}
+	c.external = external
+	_, err = c.ConnDbNameE(c.cfg.dbName)
+	return err
+}
+
+// dbName returns the name of the database on the server
+func (c *testDBMysql) dbName(name string) string {
+	if c.external != nil {
+		return c.external.dbName(name)
+	}
+	return name
+}
+
+// dsn returns the dsn to connect to the database name on the container
+func (c *testDBMysql) dsn(host, port, name string) string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local", c.cfg.user, c.cfg.password, host, port, name)
+}
+
+// open returns a gorm connection to the database with the given name
+func (c *testDBMysql) open(name string) (*gorm.DB, error) {
+	dsn := c.dsn(c.host, c.port, name)
+	if c.external != nil {
+		var err error
+		dsn, err = mysqlDsnWithDb(c.external.dsn, name)
 		if err != nil {
-			panic(fmt.Sprintf("failed to connect to MySQL test database: %v", err))
+			return nil, fmt.Errorf("%w: %w", ErrConnection, err)
 		}
-		cleanFn := func() {
-			if err := mysqlContainer.Terminate(ctx); err != nil {
-				panic(fmt.Sprintf("failed to terminate MySQL container: %v", err))
-			}
-		}
-		c.clean = cleanFn
-		c.pool[normalizeDbName(defaultDbName)] = db
+	}
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
+		Logger: c.logger,
 	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to connect to MySQL test database: %w", ErrConnection, err)
+	}
+	registerRecorder(db)
+	return db, nil
 }
 
 func (c *testDBMysql) Conn() *gorm.DB {
-	return c.ConnDbName(defaultDbName)
+	return c.ConnDbName(c.cfg.dbName)
+}
+
+func (c *testDBMysql) ConnE() (*gorm.DB, error) {
+	return c.ConnDbNameE(c.cfg.dbName)
 }
 
 func (c *testDBMysql) ConnDbName(name string) *gorm.DB {
-	name = normalizeDbName(name)
-	dbConn, exists := c.pool[name]
-	if exists {
-		return dbConn
+	db, err := c.ConnDbNameE(name)
+	if err != nil {
+		panic(err)
 	}
+	return db
+}
+
+func (c *testDBMysql) ConnDbNameE(name string) (*gorm.DB, error) {
+	name = normalizeDbName(name)
+	return c.pool.getOrCreate(name, func() (*gorm.DB, error) {
+		dbName := c.dbName(name)
+		if err := c.createDb(dbName); err != nil {
+			return nil, err
+		}
+		return c.open(dbName)
+	})
+}
 
-	dsn := fmt.Sprintf("root:%s@tcp(%s:%s)/", mysqlPassword, c.host, c.port)
+// rootConn opens a connection with the root user, or the user of the external dsn, used to create and drop databases
+func (c *testDBMysql) rootConn() (*sql.DB, error) {
+	dsn := fmt.Sprintf("root:%s@tcp(%s:%s)/", c.cfg.password, c.host, c.port)
+	if c.external != nil {
+		var err error
+		dsn, err = mysqlDsnWithDb(c.external.dsn, "")
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrConnection, err)
+		}
+	}
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("%w: %w", ErrConnection, err)
+	}
+	return db, nil
+}
+
+// createDb creates a new database, if it does not exist, and grants the test user access to it
+func (c *testDBMysql) createDb(name string) error {
+	db, err := c.rootConn()
+	if err != nil {
+		return err
 	}
 	defer db.Close()
-	_, err = db.Exec("CREATE DATABASE IF NOT EXISTS " + name)
+	_, err = db.Exec("CREATE DATABASE IF NOT EXISTS `" + name + "`")
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("%w: unable to create database %s: %w", ErrDbCreate, name, err)
+	}
+	// on external servers the databases are used with the same user that created them
+	if c.external != nil {
+		c.external.add(name)
+		return nil
 	}
-	grantQuery := fmt.Sprintf("GRANT ALL PRIVILEGES ON %s.* TO '%s'@'%%'", name, mysqlUser)
+	grantQuery := fmt.Sprintf("GRANT ALL PRIVILEGES ON `%s`.* TO '%s'@'%%'", name, c.cfg.user)
 	_, err = db.Exec(grantQuery)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("%w: unable to grant privileges on %s: %w", ErrDbCreate, name, err)
 	}
 	_, err = db.Exec("FLUSH PRIVILEGES")
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("%w: unable to flush privileges: %w", ErrDbCreate, err)
 	}
+	return nil
+}
 
-	dsn = fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local", mysqlUser, mysqlPassword, c.host, c.port, name)
-	gormDb, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
-		Logger: c.logger,
-	})
-	if err != nil {
-		panic(fmt.Sprintf("failed to connect to MySQL test database: %v", err))
+// Drop closes the connection to the database and deletes it
+func (c *testDBMysql) Drop(name string) error {
+	name = normalizeDbName(name)
+	if _, exists := c.pool.get(name); exists {
+		if err := c.closeConn(name); err != nil {
+			return err
+		}
 	}
+	return c.dropDatabase(c.dbName(name))
+}
 
-	c.pool[name] = gormDb
-	return gormDb
+// dropDatabase deletes the database with the given name on the server
+func (c *testDBMysql) dropDatabase(name string) error {
+	db, err := c.rootConn()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec("DROP DATABASE IF EXISTS `" + name + "`")
+	if err != nil {
+		return fmt.Errorf("unable to drop database %s: %w", name, err)
+	}
+	if c.external != nil {
+		c.external.remove(name)
+	}
+	return nil
+}
 
+// keep dumps the database with mysqldump, or mariadb-dump, run inside the container
+func (c *testDBMysql) keep(name, dir string) (string, error) {
+	if c.container == nil {
+		return "", fmt.Errorf("%w: %s can only dump databases running in a container", errors.ErrUnsupported, c.DbType())
+	}
+	dbName := c.dbName(normalizeDbName(name))
+	path := dumpPath(dir, dbName, c.DbType())
+	containerPath := "/tmp/" + dbName + ".sql"
+	// the mariadb images don't ship the mysql named binaries anymore
+	dumpCmd := "mysqldump"
+	if c.engine == DBTypeMariadb {
+		dumpCmd = "mariadb-dump"
+	}
+	cmd := []string{dumpCmd, "-uroot", "-p" + c.cfg.password, "--single-transaction", "--result-file=" + containerPath, dbName}
+	if err := dumpFromContainer(context.Background(), c.container, cmd, containerPath, path); err != nil {
+		return "", err
+	}
+	return path, nil
 }
@@ -0,0 +1,111 @@
+package testdbs
+
+import (
+	"maps"
+	"time"
+)
+
+// containerConfig holds the configuration of the DBs running in a container
+type containerConfig struct {
+	image          string
+	env            map[string]string
+	startupTimeout time.Duration
+	dbName         string
+	user           string
+	password       string
+	cmd            []string
+	label          string
+	dropOnClose    bool
+}
+
+// Option configures the DBs created with NewPostgres and NewMysql
+type Option func(cfg *containerConfig)
+
+// WithImage sets the docker image, including the tag, e.g. "postgres:16"
+func WithImage(image string) Option {
+	return func(cfg *containerConfig) {
+		cfg.image = image
+	}
+}
+
+// WithEnv adds environment variables to the container, they take precedence over the ones set by testdbs
+func WithEnv(env map[string]string) Option {
+	return func(cfg *containerConfig) {
+		if cfg.env == nil {
+			cfg.env = map[string]string{}
+		}
+		maps.Copy(cfg.env, env)
+	}
+}
+
+// WithStartupTimeout sets the max time to wait for the container to be ready
+func WithStartupTimeout(timeout time.Duration) Option {
+	return func(cfg *containerConfig) {
+		cfg.startupTimeout = timeout
+	}
+}
+
+// WithDbName sets the name of the default database returned by Conn, the name is normalized like the ones of ConnDbName
+func WithDbName(name string) Option {
+	return func(cfg *containerConfig) {
+		cfg.dbName = name
+	}
+}
+
+// WithCredentials sets the user and password used to connect, on mysql the password is also used for the root user,
+// sql server only accepts the user sa
+func WithCredentials(user, password string) Option {
+	return func(cfg *containerConfig) {
+		cfg.user = user
+		cfg.password = password
+	}
+}
+
+// WithCmd sets the command line arguments of the database server, e.g. "-c", "max_connections=200" on postgres
+func WithCmd(args ...string) Option {
+	return func(cfg *containerConfig) {
+		cfg.cmd = args
+	}
+}
+
+// WithLabel sets the value returned by DbType, used to tell apart several DBs of the same engine, e.g. "postgres-15"
+func WithLabel(label string) Option {
+	return func(cfg *containerConfig) {
+		cfg.label = label
+	}
+}
+
+// WithDropOnClose drops the database on Close(name) instead of only closing the connection, only Close honours it:
+// CloseAll terminates the container, or on external servers drops the databases created by testdbs, in any case
+func WithDropOnClose() Option {
+	return func(cfg *containerConfig) {
+		cfg.dropOnClose = true
+	}
+}
+
+// withDefaults sets the values that were not configured
+func (cfg *containerConfig) withDefaults(defaults containerConfig) {
+	if cfg.image == "" {
+		cfg.image = defaults.image
+	}
+	if cfg.startupTimeout == 0 {
+		cfg.startupTimeout = defaults.startupTimeout
+	}
+	if cfg.dbName == "" {
+		cfg.dbName = defaults.dbName
+	}
+	// the default database is created with the same name that Close and Drop use
+	cfg.dbName = normalizeDbName(cfg.dbName)
+	if cfg.user == "" {
+		cfg.user = defaults.user
+	}
+	if cfg.password == "" {
+		cfg.password = defaults.password
+	}
+}
+
+// containerEnv returns the env of the container, the configured env overrides the passed one
+func (cfg *containerConfig) containerEnv(env map[string]string) map[string]string {
+	maps.Copy(env, cfg.env)
+	return env
+}
@@ -0,0 +1,77 @@
+package testdbs
+
+import (
+	"golang.org/x/sync/singleflight"
+	"gorm.io/gorm"
+	"sync"
+)
+
+// connPool holds the named gorm connections of a TargetDb, it is safe for concurrent use.
+// Concurrent requests for the same missing name are deduplicated, so the database is only created once.
+type connPool struct {
+	mu    sync.RWMutex
+	conns map[string]*gorm.DB
+	group singleflight.Group
+}
+
+// get returns the connection stored with name
+func (p *connPool) get(name string) (*gorm.DB, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	db, exists := p.conns[name]
+	return db, exists
+}
+
+// set stores the connection with name, replacing any previous one
+func (p *connPool) set(name string, db *gorm.DB) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conns == nil {
+		p.conns = map[string]*gorm.DB{}
+	}
+	p.conns[name] = db
+}
+
+// getOrCreate returns the connection stored with name, if it does not exist create is called
+// and the result is stored; concurrent calls for the same name share a single call to create.
+func (p *connPool) getOrCreate(name string, create func() (*gorm.DB, error)) (*gorm.DB, error) {
+	if db, exists := p.get(name); exists {
+		return db, nil
+	}
+	v, err, _ := p.group.Do(name, func() (any, error) {
+		// the connection might have been stored after the first check
+		if db, exists := p.get(name); exists {
+			return db, nil
+		}
+		db, err := create()
+		if err != nil {
+			return nil, err
+		}
+		p.set(name, db)
+		return db, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*gorm.DB), nil
+}
+
+// remove deletes the connection from the pool and returns it, the connection is not closed
+func (p *connPool) remove(name string) (*gorm.DB, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	db, exists := p.conns[name]
+	delete(p.conns, name)
+	return db, exists
+}
+
+// names returns the names of all the connections in the pool
+func (p *connPool) names() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	names := make([]string, 0, len(p.conns))
+	for name := range p.conns {
+		names = append(names, name)
+	}
+	return names
+}
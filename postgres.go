@@ -2,13 +2,15 @@ package testdbs
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/docker/go-connections/nat"
 	"github.com/hashicorp/go-multierror"
 	"github.com/testcontainers/testcontainers-go"
-	"github.com/testcontainers/testcontainers-go/wait"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
+	"os"
 	"sync"
 	"time"
 )
@@ -18,16 +20,37 @@ const (
 )
 
 type testDBPostgres struct {
-	once   sync.Once
-	logger logger.Interface
-	host   string
-	port   string
-	pool   map[string]*gorm.DB
-	clean  func()
+	// engine is returned by DbType, it is empty for postgres
+	engine    string
+	cfg       containerConfig
+	once      sync.Once
+	initErr   error
+	logger    logger.Interface
+	host      string
+	port      string
+	pool      connPool
+	templates templateSet
+	clean     func() error
+	external  *externalDb
+	// container is nil on external servers
+	container testcontainers.Container
 }
 
+// Close closes the connection to the database, the database is also dropped when configured with WithDropOnClose
 func (c *testDBPostgres) Close(name string) error {
-	db, exists := c.pool[name]
+	name = normalizeDbName(name)
+	if err := c.closeConn(name); err != nil {
+		return err
+	}
+	if c.cfg.dropOnClose {
+		return c.dropDatabase(c.dbName(name))
+	}
+	return nil
+}
+
+// closeConn closes the connection to the database keeping the database
+func (c *testDBPostgres) closeConn(name string) error {
+	db, exists := c.pool.remove(name)
 	if !exists {
 		return fmt.Errorf("db connection with name %s not found", name)
 	}
@@ -49,119 +72,474 @@ func (c *testDBPostgres) Close(name string) error {
 	if err != nil {
 		return fmt.Errorf("error closing database connection: %w", err)
 	}
-
-	// Remove from pool
-	delete(c.pool, name)
-
 	return nil
 }
 
 func (c *testDBPostgres) CloseAll() error {
-	defer c.clean()
 	var merr error
-	for name, _ := range c.pool {
-		err := c.Close(name)
+	for _, name := range c.pool.names() {
+		err := c.closeConn(name)
 		if err != nil {
 			merr = multierror.Append(merr, err)
 		}
 	}
-	c.pool = nil
+	// only drop the databases created by testdbs on external servers
+	if c.external != nil {
+		for _, name := range c.external.names() {
+			if err := c.dropDatabase(name); err != nil {
+				merr = multierror.Append(merr, err)
+			}
+		}
+	}
+	if c.clean != nil {
+		if err := c.clean(); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
 	return merr
 }
 
 func (c *testDBPostgres) DbType() string {
+	if c.cfg.label != "" {
+		return c.cfg.label
+	}
+	if c.engine != "" {
+		return c.engine
+	}
 	return DBTypePostgres
 }
 
 const (
 	postgresPassword = "password"
 	postgresUser     = "testuser"
+	postgresImage    = "postgres:13"
 )
 
+// NewPostgres returns a postgres TargetDb running in a container configured with the options
+func NewPostgres(opts ...Option) TargetDb {
+	c := &testDBPostgres{}
+	for _, opt := range opts {
+		opt(&c.cfg)
+	}
+	return c
+}
+
+// PostgresVersions returns a postgres TargetDb for every version, the image tag is the version
+// and DbType returns the engine followed by the version, e.g. "postgres-15".
+func PostgresVersions(versions ...string) []TargetDb {
+	dbs := make([]TargetDb, 0, len(versions))
+	for _, version := range versions {
+		dbs = append(dbs, NewPostgres(
+			WithImage("postgres:"+version),
+			WithLabel(DBTypePostgres+"-"+version),
+		))
+	}
+	return dbs
+}
+
 func (c *testDBPostgres) Init(logger logger.Interface) {
-	c.logger = logger
-	c.pool = map[string]*gorm.DB{}
+	if err := c.InitE(logger); err != nil {
+		panic(err)
+	}
+}
 
+func (c *testDBPostgres) InitE(logger logger.Interface) error {
+	c.logger = logger
 	c.once.Do(func() {
-		ctx := context.Background()
-
-		req := testcontainers.ContainerRequest{
-			Image:        "postgres:13",
-			ExposedPorts: []string{"5432/tcp"},
-			Env: map[string]string{
-				"POSTGRES_USER":     postgresUser,
-				"POSTGRES_PASSWORD": postgresPassword,
-				"POSTGRES_DB":       defaultDbName,
-			},
-			WaitingFor: wait.ForListeningPort("5432/tcp").WithStartupTimeout(60 * time.Second),
-		}
-
-		postgresContainer, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
-			ContainerRequest: req,
-			Started:          true,
+		c.cfg.withDefaults(containerConfig{
+			image:          postgresImage,
+			startupTimeout: 60 * time.Second,
+			dbName:         defaultDbName,
+			user:           postgresUser,
+			password:       postgresPassword,
 		})
-		if err != nil {
-			panic(fmt.Sprintf("failed to start PostgreSQL container: %v", err))
-		}
+		c.initErr = c.start()
+	})
+	return c.initErr
+}
 
-		host, err := postgresContainer.Host(ctx)
-		if err != nil {
-			panic(fmt.Sprintf("failed to get PostgreSQL container host: %v", err))
-		}
-		c.host = host
+// start runs the postgres container and opens the connection to the default DB,
+// if any step fails the container is terminated again.
+func (c *testDBPostgres) start() (err error) {
+	if dsn := os.Getenv(c.dsnEnv()); dsn != "" {
+		return c.startExternal(dsn)
+	}
+	if sharedContainers() {
+		return c.startShared()
+	}
+	ctx := context.Background()
 
-		port, err := postgresContainer.MappedPort(ctx, "5432")
+	postgresContainer, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: c.containerRequest(),
+		Started:          true,
+	})
+	cleanFn := func() error {
+		if err := testcontainers.TerminateContainer(postgresContainer); err != nil {
+			return fmt.Errorf("failed to terminate postgres container: %w", err)
+		}
+		return nil
+	}
+	defer func() {
 		if err != nil {
-			panic(fmt.Sprintf("failed to get PostgreSQL container port: %v", err))
+			if cErr := cleanFn(); cErr != nil {
+				err = multierror.Append(err, cErr)
+			}
 		}
-		c.port = port.Port()
+	}()
+	if err != nil {
+		return fmt.Errorf("%w: failed to start PostgreSQL container: %w", ErrContainerStart, err)
+	}
+
+	host, err := postgresContainer.Host(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: failed to get PostgreSQL container host: %w", ErrContainerStart, err)
+	}
+	c.host = host
 
-		dsn := fmt.Sprintf("host=%s port=%s user=%s dbname=%s password=%s sslmode=disable", host, port.Port(), postgresUser, defaultDbName, postgresPassword)
-		db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
-			Logger: logger,
+	port, err := postgresContainer.MappedPort(ctx, c.containerPort())
+	if err != nil {
+		return fmt.Errorf("%w: failed to get PostgreSQL container port: %w", ErrContainerStart, err)
+	}
+	c.port = port.Port()
+
+	// the postgres image creates the default DB, this is a no-op unless the engine is cockroachdb
+	if err := c.createDb(c.cfg.dbName); err != nil {
+		return err
+	}
+	db, err := c.open(c.cfg.dbName)
+	if err != nil {
+		return err
+	}
+
+	c.clean = cleanFn
+	c.container = postgresContainer
+	c.pool.set(c.cfg.dbName, db)
+	return nil
+}
+
+// dsnEnv returns the env holding the dsn of an external server
+func (c *testDBPostgres) dsnEnv() string {
+	if c.engine == DBTypeCockroachdb {
+		return CockroachdbDsnEnv
+	}
+	return PostgresDsnEnv
+}
+
+// containerPort returns the port the database listens to
+func (c *testDBPostgres) containerPort() nat.Port {
+	if c.engine == DBTypeCockroachdb {
+		return "26257"
+	}
+	return "5432"
+}
+
+// containerRequest returns the request to start the postgres container
+func (c *testDBPostgres) containerRequest() testcontainers.ContainerRequest {
+	if c.engine == DBTypeCockroachdb {
+		return cockroachdbRequest(c.cfg, func(host, port string) string {
+			return c.dsn(host, port, cockroachdbAdminDb)
 		})
-		if err != nil {
-			panic(fmt.Sprintf("failed to connect to PostgreSQL test database: %v", err))
+	}
+	return testcontainers.ContainerRequest{
+		Image:        c.cfg.image,
+		ExposedPorts: []string{"5432/tcp"},
+		Env: c.cfg.containerEnv(map[string]string{
+			"POSTGRES_USER":     c.cfg.user,
+			"POSTGRES_PASSWORD": c.cfg.password,
+			"POSTGRES_DB":       c.cfg.dbName,
+		}),
+		Cmd: c.cfg.cmd,
+		WaitingFor: waitForQuery("5432/tcp", "pgx", c.cfg.startupTimeout, func(host, port string) string {
+			return c.dsn(host, port, c.cfg.dbName)
+		}),
+	}
+}
+
+// startShared attaches to the postgres container shared with the test binaries of other packages,
+// like on external servers the databases are created with a unique prefix so that packages don't interfere.
+func (c *testDBPostgres) startShared() error {
+	ctx := context.Background()
+	shared, err := startSharedContainer(ctx, c.containerRequest())
+	if err != nil {
+		return err
+	}
+	host, port, err := shared.address(ctx, c.containerPort())
+	if err == nil {
+		err = c.startExternal(c.dsn(host, port, c.adminDbName()))
+	}
+	if err != nil {
+		if rErr := shared.release(); rErr != nil {
+			err = multierror.Append(err, rErr)
 		}
+		return err
+	}
+	c.clean = shared.release
+	c.container = shared.container
+	return nil
+}
 
-		cleanFn := func() {
-			if err := postgresContainer.Terminate(ctx); err != nil {
-				panic(fmt.Sprintf("failed to terminate postgres container: %v", err))
-			}
+// startExternal uses the server of the dsn instead of starting a container, the default DB
+// is created like any other database so that the existing data is not modified.
+func (c *testDBPostgres) startExternal(dsn string) error {
+	external, err := newExternalDb(dsn)
+	if err != nil {
+		return err
+	}
+	c.external = external
+	_, err = c.ConnDbNameE(c.cfg.dbName)
+	return err
+}
+
+// dbName returns the name of the database on the server
+func (c *testDBPostgres) dbName(name string) string {
+	if c.external != nil {
+		return c.external.dbName(name)
+	}
+	return name
+}
+
+// dsn returns the dsn to connect to the database name on the container
+func (c *testDBPostgres) dsn(host, port, name string) string {
+	return fmt.Sprintf("host=%s port=%s user=%s dbname=%s password=%s sslmode=disable", host, port, c.cfg.user, name, c.cfg.password)
+}
+
+// adminDbName returns the database used to create and drop other databases
+func (c *testDBPostgres) adminDbName() string {
+	if c.engine == DBTypeCockroachdb {
+		return cockroachdbAdminDb
+	}
+	return c.cfg.dbName
+}
+
+// open returns a gorm connection to the database with the given name
+func (c *testDBPostgres) open(name string) (*gorm.DB, error) {
+	dsn := c.dsn(c.host, c.port, name)
+	if c.external != nil {
+		var err error
+		dsn, err = postgresDsnWithDb(c.external.dsn, name)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrConnection, err)
 		}
-		c.clean = cleanFn
-		c.pool[normalizeDbName(defaultDbName)] = db
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger: c.logger,
 	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to connect to PostgreSQL test database: %w", ErrConnection, err)
+	}
+	registerRecorder(db)
+	return db, nil
+}
+
+// adminConn opens a connection used to create and drop databases
+func (c *testDBPostgres) adminConn() (*gorm.DB, error) {
+	if c.external == nil {
+		return c.open(c.adminDbName())
+	}
+	db, err := gorm.Open(postgres.Open(c.external.dsn), &gorm.Config{
+		Logger: c.logger,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to connect to PostgreSQL server: %w", ErrConnection, err)
+	}
+	return db, nil
 }
 
 func (c *testDBPostgres) Conn() *gorm.DB {
-	return c.ConnDbName(defaultDbName)
+	return c.ConnDbName(c.cfg.dbName)
+}
+
+func (c *testDBPostgres) ConnE() (*gorm.DB, error) {
+	return c.ConnDbNameE(c.cfg.dbName)
 }
 
 func (c *testDBPostgres) ConnDbName(name string) *gorm.DB {
-	name = normalizeDbName(name)
-	dbConn, exists := c.pool[name]
-	if exists {
-		return dbConn
+	db, err := c.ConnDbNameE(name)
+	if err != nil {
+		panic(err)
 	}
+	return db
+}
 
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s  sslmode=disable", c.host, c.port, postgresUser, postgresPassword, defaultDbName)
-	DB, _ := gorm.Open(postgres.Open(dsn), &gorm.Config{
-		Logger: c.logger,
+func (c *testDBPostgres) ConnDbNameE(name string) (*gorm.DB, error) {
+	name = normalizeDbName(name)
+	return c.pool.getOrCreate(name, func() (*gorm.DB, error) {
+		dbName := c.dbName(name)
+		if err := c.createDb(dbName); err != nil {
+			return nil, err
+		}
+		return c.open(dbName)
 	})
+}
 
-	createDatabaseCommand := fmt.Sprintf("CREATE DATABASE %s", name)
-	DB.Exec(createDatabaseCommand)
+// createDb creates a new database unless it already exists
+func (c *testDBPostgres) createDb(name string) error {
+	admin, err := c.adminConn()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if sqlDb, err := admin.DB(); err == nil {
+			_ = sqlDb.Close()
+		}
+	}()
 
-	dsn = fmt.Sprintf("host=%s port=%s user=%s dbname=%s password=%s sslmode=disable", c.host, c.port, postgresUser, name, postgresPassword)
-	gormDb, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
-		Logger: c.logger,
+	if c.engine == DBTypeCockroachdb {
+		// cockroachdb supports IF NOT EXISTS, it is safe with concurrent creates from other test binaries
+		err = admin.Exec(fmt.Sprintf(`CREATE DATABASE IF NOT EXISTS "%s"`, name)).Error
+		if err != nil {
+			return fmt.Errorf("%w: unable to create database %s: %w", ErrDbCreate, name, err)
+		}
+		if c.external != nil {
+			c.external.add(name)
+		}
+		return nil
+	}
+
+	var count int64
+	err = admin.Raw("SELECT count(*) FROM pg_database WHERE datname = ?", name).Scan(&count).Error
+	if err != nil {
+		return fmt.Errorf("%w: unable to check if database %s exists: %w", ErrDbCreate, name, err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	err = admin.Exec(fmt.Sprintf(`CREATE DATABASE "%s"`, name)).Error
+	if err != nil {
+		return fmt.Errorf("%w: unable to create database %s: %w", ErrDbCreate, name, err)
+	}
+	if c.external != nil {
+		c.external.add(name)
+	}
+	return nil
+}
+
+// Drop closes the connection to the database and deletes it
+func (c *testDBPostgres) Drop(name string) error {
+	name = normalizeDbName(name)
+	if _, exists := c.pool.get(name); exists {
+		if err := c.closeConn(name); err != nil {
+			return err
+		}
+	}
+	return c.dropDatabase(c.dbName(name))
+}
+
+// dropDatabase deletes the database with the given name on the server,
+// on postgres the connections still open to it, e.g. from other processes, are terminated first.
+func (c *testDBPostgres) dropDatabase(name string) error {
+	admin, err := c.adminConn()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if sqlDb, err := admin.DB(); err == nil {
+			_ = sqlDb.Close()
+		}
+	}()
+
+	if c.engine != DBTypeCockroachdb {
+		err = admin.Exec("SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = ? AND pid <> pg_backend_pid()", name).Error
+		if err != nil {
+			return fmt.Errorf("unable to terminate connections to database %s: %w", name, err)
+		}
+	}
+
+	err = admin.Exec(fmt.Sprintf(`DROP DATABASE IF EXISTS "%s"`, name)).Error
+	if err != nil {
+		return fmt.Errorf("unable to drop database %s: %w", name, err)
+	}
+	if c.external != nil {
+		c.external.remove(name)
+	}
+	return nil
+}
+
+// PrepareTemplate creates the template database and calls prepare with a connection to it,
+// the connection is closed afterward since postgres can't copy a database that is in use.
+func (c *testDBPostgres) PrepareTemplate(name string, prepare func(db *gorm.DB) error) error {
+	if c.engine == DBTypeCockroachdb {
+		return fmt.Errorf("%w: %s does not support template databases", errors.ErrUnsupported, c.DbType())
+	}
+	name = normalizeDbName(name)
+	return c.templates.prepare(name, func() error {
+		db, err := c.ConnDbNameE(name)
+		if err != nil {
+			return err
+		}
+		err = prepare(db)
+		if cErr := c.closeConn(name); cErr != nil {
+			err = multierror.Append(err, cErr)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to prepare template %s: %w", name, err)
+		}
+		return nil
 	})
+}
+
+// ConnFromTemplate creates the database newName with CREATE DATABASE ... TEMPLATE and returns a connection to it
+func (c *testDBPostgres) ConnFromTemplate(templateName, newName string) (*gorm.DB, error) {
+	if c.engine == DBTypeCockroachdb {
+		return nil, fmt.Errorf("%w: %s does not support template databases", errors.ErrUnsupported, c.DbType())
+	}
+	templateName = normalizeDbName(templateName)
+	newName = normalizeDbName(newName)
+	if !c.templates.ready(templateName) {
+		return nil, fmt.Errorf("%w: template %s was not prepared", ErrDbCreate, templateName)
+	}
+	return c.pool.getOrCreate(newName, func() (*gorm.DB, error) {
+		dbName := c.dbName(newName)
+		if err := c.createDbFromTemplate(c.dbName(templateName), dbName); err != nil {
+			return nil, err
+		}
+		return c.open(dbName)
+	})
+}
+
+// createDbFromTemplate creates a new database as a copy of the template unless it already exists
+func (c *testDBPostgres) createDbFromTemplate(templateName, name string) error {
+	admin, err := c.adminConn()
 	if err != nil {
-		panic(fmt.Sprintf("failed to connect to PostgreSQL test database: %v", err))
+		return err
 	}
+	defer func() {
+		if sqlDb, err := admin.DB(); err == nil {
+			_ = sqlDb.Close()
+		}
+	}()
 
-	c.pool[name] = gormDb
-	return gormDb
+	var count int64
+	err = admin.Raw("SELECT count(*) FROM pg_database WHERE datname = ?", name).Scan(&count).Error
+	if err != nil {
+		return fmt.Errorf("%w: unable to check if database %s exists: %w", ErrDbCreate, name, err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	err = admin.Exec(fmt.Sprintf(`CREATE DATABASE "%s" TEMPLATE "%s"`, name, templateName)).Error
+	if err != nil {
+		return fmt.Errorf("%w: unable to create database %s from template %s: %w", ErrDbCreate, name, templateName, err)
+	}
+	if c.external != nil {
+		c.external.add(name)
+	}
+	return nil
+}
 
+// keep dumps the database with pg_dump run inside the container
+func (c *testDBPostgres) keep(name, dir string) (string, error) {
+	if c.container == nil || c.engine == DBTypeCockroachdb {
+		return "", fmt.Errorf("%w: %s can only dump databases running in a postgres container", errors.ErrUnsupported, c.DbType())
+	}
+	dbName := c.dbName(normalizeDbName(name))
+	path := dumpPath(dir, dbName, c.DbType())
+	containerPath := "/tmp/" + dbName + ".sql"
+	cmd := []string{"pg_dump", "-U", c.cfg.user, "-d", dbName, "-f", containerPath}
+	if err := dumpFromContainer(context.Background(), c.container, cmd, containerPath, path); err != nil {
+		return "", err
+	}
+	return path, nil
 }
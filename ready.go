@@ -0,0 +1,78 @@
+package testdbs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/docker/go-connections/nat"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"time"
+)
+
+const (
+	readyMinBackoff = 100 * time.Millisecond
+	readyMaxBackoff = 2 * time.Second
+)
+
+// forQuery is a wait.Strategy that considers the database ready once it can authenticate and run SELECT 1,
+// an open port is not enough since some images, e.g. mysql 8, restart the server after the initialization.
+// The check is retried with exponential backoff until the timeout.
+type forQuery struct {
+	port    nat.Port
+	driver  string
+	dsn     func(host, port string) string
+	timeout time.Duration
+}
+
+func waitForQuery(port nat.Port, driver string, timeout time.Duration, dsn func(host, port string) string) *forQuery {
+	return &forQuery{
+		port:    port,
+		driver:  driver,
+		dsn:     dsn,
+		timeout: timeout,
+	}
+}
+
+// Timeout returns the startup timeout, it implements wait.StrategyTimeout
+func (w *forQuery) Timeout() *time.Duration {
+	return &w.timeout
+}
+
+func (w *forQuery) WaitUntilReady(ctx context.Context, target wait.StrategyTarget) error {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+
+	backoff := readyMinBackoff
+	for {
+		err := w.query(ctx, target)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("database not ready after %s: %w", w.timeout, err)
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, readyMaxBackoff)
+	}
+}
+
+// query runs SELECT 1 on a new connection to the database
+func (w *forQuery) query(ctx context.Context, target wait.StrategyTarget) error {
+	host, err := target.Host(ctx)
+	if err != nil {
+		return err
+	}
+	port, err := target.MappedPort(ctx, w.port)
+	if err != nil {
+		return err
+	}
+	db, err := sql.Open(w.driver, w.dsn(host, port.Port()))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var one int
+	return db.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+}
@@ -0,0 +1,183 @@
+package testdbs
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	recorderKey      = "testdbs:recorder"
+	recorderStartKey = "testdbs:recorder_start"
+	recorderCallback = "testdbs:record"
+)
+
+// Query is a statement run through a recorded connection
+type Query struct {
+	SQL          string
+	Vars         []any
+	RowsAffected int64
+	Duration     time.Duration
+	// Caller is the file:line of the code that ran the statement
+	Caller string
+	Err    error
+}
+
+func (q Query) String() string {
+	return fmt.Sprintf("%s %v (%s)", q.SQL, q.Vars, q.Caller)
+}
+
+// Recorder holds the statements run through the connection returned by Record, it is safe for concurrent use
+type Recorder struct {
+	mu      sync.Mutex
+	queries []Query
+}
+
+// Record returns a connection that records every statement run through it, and the sessions derived from it,
+// in the returned Recorder. The statements of db itself are not recorded.
+func Record(db *gorm.DB) (*gorm.DB, *Recorder) {
+	registerRecorder(db)
+	r := &Recorder{}
+	// the new session keeps the conditions of one call from leaking into the next ones on the returned db
+	return db.Set(recorderKey, r).Session(&gorm.Session{}), r
+}
+
+// Queries returns a copy of the recorded statements in the order they completed
+func (r *Recorder) Queries() []Query {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.queries)
+}
+
+// Reset forgets the recorded statements, e.g. after preparing the data of a test
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queries = nil
+}
+
+// ExpectQueryCount fails the test if the number of recorded statements is not n, the statements are listed
+func (r *Recorder) ExpectQueryCount(t testing.TB, n int) {
+	t.Helper()
+	queries := r.Queries()
+	if len(queries) != n {
+		t.Errorf("expected %d queries, got %d:%s", n, len(queries), listQueries(queries))
+	}
+}
+
+// ExpectNoQueryMatching fails the test if the SQL of a recorded statement matches the regular expression pattern
+func (r *Recorder) ExpectNoQueryMatching(t testing.TB, pattern string) {
+	t.Helper()
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		t.Fatalf("invalid pattern %q: %v", pattern, err)
+	}
+	var matching []Query
+	for _, q := range r.Queries() {
+		if re.MatchString(q.SQL) {
+			matching = append(matching, q)
+		}
+	}
+	if len(matching) > 0 {
+		t.Errorf("expected no query matching %q, got %d:%s", pattern, len(matching), listQueries(matching))
+	}
+}
+
+func (r *Recorder) add(q Query) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queries = append(r.queries, q)
+}
+
+// listQueries returns the queries one per line
+func listQueries(queries []Query) string {
+	var b strings.Builder
+	for _, q := range queries {
+		b.WriteString("\n\t" + q.String())
+	}
+	return b.String()
+}
+
+// registerMu guards the registration of the callbacks, gorm does not synchronize changes to the callbacks
+var registerMu sync.Mutex
+
+// registerRecorder adds the recording callbacks to db unless they are already registered, the callbacks
+// do nothing on sessions without a Recorder. The connections opened by testdbs register them when opened
+// so that Record does not change the callbacks while other tests use the connection, registering them lazily
+// would race with the statements of parallel tests. The cost on statements that are not recorded is a lookup
+// of the recorder in the settings of the statement before and after running it.
+func registerRecorder(db *gorm.DB) {
+	registerMu.Lock()
+	defer registerMu.Unlock()
+	if db.Callback().Query().Get(recorderCallback) != nil {
+		return
+	}
+
+	cb := db.Callback()
+	// errors are only returned for duplicated names, checked above
+	_ = cb.Create().Before("*").Register(recorderCallback+"_start", recordStart)
+	_ = cb.Create().After("*").Register(recorderCallback, recordQuery)
+	_ = cb.Query().Before("*").Register(recorderCallback+"_start", recordStart)
+	_ = cb.Query().After("*").Register(recorderCallback, recordQuery)
+	_ = cb.Update().Before("*").Register(recorderCallback+"_start", recordStart)
+	_ = cb.Update().After("*").Register(recorderCallback, recordQuery)
+	_ = cb.Delete().Before("*").Register(recorderCallback+"_start", recordStart)
+	_ = cb.Delete().After("*").Register(recorderCallback, recordQuery)
+	_ = cb.Row().Before("*").Register(recorderCallback+"_start", recordStart)
+	_ = cb.Row().After("*").Register(recorderCallback, recordQuery)
+	_ = cb.Raw().Before("*").Register(recorderCallback+"_start", recordStart)
+	_ = cb.Raw().After("*").Register(recorderCallback, recordQuery)
+}
+
+func recordStart(db *gorm.DB) {
+	if _, ok := db.Get(recorderKey); ok {
+		db.InstanceSet(recorderStartKey, time.Now())
+	}
+}
+
+func recordQuery(db *gorm.DB) {
+	v, ok := db.Get(recorderKey)
+	if !ok {
+		return
+	}
+	r, ok := v.(*Recorder)
+	if !ok {
+		return
+	}
+	q := Query{
+		SQL:          db.Statement.SQL.String(),
+		Vars:         slices.Clone(db.Statement.Vars),
+		RowsAffected: db.RowsAffected,
+		Caller:       caller(),
+		Err:          db.Error,
+	}
+	if start, ok := db.InstanceGet(recorderStartKey); ok {
+		q.Duration = time.Since(start.(time.Time))
+	}
+	r.add(q)
+}
+
+// caller returns the file:line of the first frame outside gorm, database/sql and the non test files of testdbs
+func caller() string {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		internal := strings.HasPrefix(frame.Function, "gorm.io/") ||
+			strings.HasPrefix(frame.Function, "database/sql") ||
+			(strings.HasPrefix(frame.Function, "github.com/go-bumbu/testdbs.") && !strings.HasSuffix(frame.File, "_test.go"))
+		if !internal {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
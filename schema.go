@@ -0,0 +1,490 @@
+package testdbs
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Schema is an engine-neutral view of the tables of a database, used to assert the result of migrations.
+// Column types are normalized, e.g. int4 and int(11) are "integer", varchar(100) and character varying(100)
+// are "varchar(100)" and all the timestamp types are "timestamp".
+type Schema struct {
+	Tables []Table
+}
+
+// Table is a table of a Schema, the columns are in the order of the table
+type Table struct {
+	Name        string
+	Columns     []Column
+	PrimaryKey  []string
+	Indexes     []Index
+	ForeignKeys []ForeignKey
+}
+
+type Column struct {
+	Name     string
+	Type     string
+	Nullable bool
+}
+
+// Index is an index of a Table, the index of the primary key is not included
+type Index struct {
+	Name    string
+	Columns []string
+	Unique  bool
+}
+
+// ForeignKey is a foreign key of a Table, the name is not included since not all engines name them
+type ForeignKey struct {
+	Columns           []string
+	ReferencedTable   string
+	ReferencedColumns []string
+}
+
+// Table returns the table with the given name
+func (s Schema) Table(name string) (Table, bool) {
+	idx := slices.IndexFunc(s.Tables, func(t Table) bool {
+		return t.Name == name
+	})
+	if idx == -1 {
+		return Table{}, false
+	}
+	return s.Tables[idx], true
+}
+
+// Inspect returns the schema of the database name of the TargetDb, the database is created if it does not exist.
+// Postgres, cockroachdb, mysql, mariadb and sql server are read from information_schema, sqlite from sqlite_master
+// and pragmas, other engines return errors.ErrUnsupported.
+func Inspect(dbt TargetDb, name string) (Schema, error) {
+	db, err := dbt.ConnDbNameE(name)
+	if err != nil {
+		return Schema{}, err
+	}
+	return InspectConn(db)
+}
+
+// InspectConn is like Inspect but reads the schema through a connection, e.g. one returned by ForTest
+func InspectConn(db *gorm.DB) (Schema, error) {
+	var (
+		s   Schema
+		err error
+	)
+	switch db.Dialector.Name() {
+	case "postgres":
+		s, err = inspectInformationSchema(db, "current_schema()")
+	case "mysql":
+		s, err = inspectInformationSchema(db, "DATABASE()")
+	case "sqlserver":
+		s, err = inspectInformationSchema(db, "SCHEMA_NAME()")
+	case "sqlite":
+		s, err = inspectSqlite(db)
+	default:
+		return Schema{}, fmt.Errorf("%w: unable to inspect the schema of %s", errors.ErrUnsupported, db.Dialector.Name())
+	}
+	if err != nil {
+		return Schema{}, err
+	}
+
+	for i := range s.Tables {
+		t := &s.Tables[i]
+		for j := range t.Columns {
+			// primary key columns can't be null, even if sqlite reports them as nullable
+			if slices.Contains(t.PrimaryKey, t.Columns[j].Name) {
+				t.Columns[j].Nullable = false
+			}
+		}
+		t.Indexes, err = inspectIndexes(db, t.Name)
+		if err != nil {
+			return Schema{}, err
+		}
+		slices.SortFunc(t.ForeignKeys, func(a, b ForeignKey) int {
+			return strings.Compare(strings.Join(a.Columns, ","), strings.Join(b.Columns, ","))
+		})
+	}
+	slices.SortFunc(s.Tables, func(a, b Table) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return s, nil
+}
+
+// inspectInformationSchema reads the schema of postgres, mysql and sql server, schemaExpr returns the current schema
+func inspectInformationSchema(db *gorm.DB, schemaExpr string) (Schema, error) {
+	var tables []string
+	err := db.Raw(fmt.Sprintf(`SELECT table_name AS table_name FROM information_schema.tables
+WHERE table_schema = %s AND table_type = 'BASE TABLE'`, schemaExpr)).Scan(&tables).Error
+	if err != nil {
+		return Schema{}, fmt.Errorf("unable to list tables: %w", err)
+	}
+	s := Schema{}
+	byName := map[string]*Table{}
+	for _, name := range tables {
+		s.Tables = append(s.Tables, Table{Name: name})
+	}
+	for i := range s.Tables {
+		byName[s.Tables[i].Name] = &s.Tables[i]
+	}
+
+	// mysql reports the length in the column type, e.g. varchar(100) or tinyint(1)
+	typeColumn := "data_type"
+	if db.Dialector.Name() == "mysql" {
+		typeColumn = "column_type"
+	}
+	var columns []struct {
+		TableName  string
+		ColumnName string
+		DataType   string
+		MaxLength  *int64
+		IsNullable string
+	}
+	err = db.Raw(fmt.Sprintf(`SELECT table_name AS table_name, column_name AS column_name, %s AS data_type,
+character_maximum_length AS max_length, is_nullable AS is_nullable
+FROM information_schema.columns WHERE table_schema = %s ORDER BY table_name, ordinal_position`, typeColumn, schemaExpr)).
+		Scan(&columns).Error
+	if err != nil {
+		return Schema{}, fmt.Errorf("unable to read the columns: %w", err)
+	}
+	for _, c := range columns {
+		if t, ok := byName[c.TableName]; ok {
+			t.Columns = append(t.Columns, Column{
+				Name:     c.ColumnName,
+				Type:     normalizeType(c.DataType, c.MaxLength),
+				Nullable: c.IsNullable == "YES",
+			})
+		}
+	}
+
+	var keyColumns []struct {
+		TableName  string
+		ColumnName string
+	}
+	err = db.Raw(fmt.Sprintf(`SELECT kcu.table_name AS table_name, kcu.column_name AS column_name
+FROM information_schema.table_constraints tc
+JOIN information_schema.key_column_usage kcu ON kcu.constraint_schema = tc.constraint_schema
+AND kcu.constraint_name = tc.constraint_name AND kcu.table_name = tc.table_name
+WHERE tc.constraint_type = 'PRIMARY KEY' AND tc.table_schema = %s
+ORDER BY kcu.table_name, kcu.ordinal_position`, schemaExpr)).Scan(&keyColumns).Error
+	if err != nil {
+		return Schema{}, fmt.Errorf("unable to read the primary keys: %w", err)
+	}
+	for _, c := range keyColumns {
+		if t, ok := byName[c.TableName]; ok {
+			t.PrimaryKey = append(t.PrimaryKey, c.ColumnName)
+		}
+	}
+
+	fkQuery := fmt.Sprintf(`SELECT kcu.table_name AS table_name, kcu.constraint_name AS constraint_name,
+kcu.column_name AS column_name, rkcu.table_name AS ref_table, rkcu.column_name AS ref_column
+FROM information_schema.referential_constraints rc
+JOIN information_schema.key_column_usage kcu ON kcu.constraint_schema = rc.constraint_schema
+AND kcu.constraint_name = rc.constraint_name
+JOIN information_schema.key_column_usage rkcu ON rkcu.constraint_schema = rc.unique_constraint_schema
+AND rkcu.constraint_name = rc.unique_constraint_name AND rkcu.ordinal_position = kcu.position_in_unique_constraint
+WHERE kcu.table_schema = %s
+ORDER BY kcu.table_name, kcu.constraint_name, kcu.ordinal_position`, schemaExpr)
+	switch db.Dialector.Name() {
+	case "sqlserver":
+		// sql server has no position_in_unique_constraint, the columns are matched by position
+		fkQuery = strings.Replace(fkQuery, "kcu.position_in_unique_constraint", "kcu.ordinal_position", 1)
+	case "mysql":
+		// mysql names every primary key PRIMARY, so joining on the referenced constraint name matches the keys
+		// of other tables, instead the referenced table and column are read from the foreign key columns themselves
+		fkQuery = fmt.Sprintf(`SELECT table_name AS table_name, constraint_name AS constraint_name,
+column_name AS column_name, referenced_table_name AS ref_table, referenced_column_name AS ref_column
+FROM information_schema.key_column_usage
+WHERE table_schema = %s AND referenced_table_name IS NOT NULL
+ORDER BY table_name, constraint_name, ordinal_position`, schemaExpr)
+	}
+	var fkColumns []foreignKeyColumn
+	if err := db.Raw(fkQuery).Scan(&fkColumns).Error; err != nil {
+		return Schema{}, fmt.Errorf("unable to read the foreign keys: %w", err)
+	}
+	addForeignKeys(byName, fkColumns)
+	return s, nil
+}
+
+// foreignKeyColumn is a column of a foreign key, the columns of a foreign key share the constraint name
+type foreignKeyColumn struct {
+	TableName      string
+	ConstraintName string
+	ColumnName     string
+	RefTable       string
+	RefColumn      string
+}
+
+// addForeignKeys groups the columns by constraint and adds the foreign keys to the tables,
+// the columns are expected to be sorted by table and constraint.
+func addForeignKeys(tables map[string]*Table, columns []foreignKeyColumn) {
+	var current *ForeignKey
+	var currentName string
+	for _, c := range columns {
+		t, ok := tables[c.TableName]
+		if !ok {
+			continue
+		}
+		name := c.TableName + "." + c.ConstraintName
+		if current == nil || name != currentName {
+			t.ForeignKeys = append(t.ForeignKeys, ForeignKey{ReferencedTable: c.RefTable})
+			current = &t.ForeignKeys[len(t.ForeignKeys)-1]
+			currentName = name
+		}
+		current.Columns = append(current.Columns, c.ColumnName)
+		current.ReferencedColumns = append(current.ReferencedColumns, c.RefColumn)
+	}
+}
+
+// inspectSqlite reads the schema of sqlite from sqlite_master and the table pragmas
+func inspectSqlite(db *gorm.DB) (Schema, error) {
+	var tables []string
+	err := db.Raw(`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'`).Scan(&tables).Error
+	if err != nil {
+		return Schema{}, fmt.Errorf("unable to list tables: %w", err)
+	}
+
+	s := Schema{}
+	byName := map[string]*Table{}
+	for _, name := range tables {
+		s.Tables = append(s.Tables, Table{Name: name})
+	}
+	for i := range s.Tables {
+		t := &s.Tables[i]
+		byName[t.Name] = t
+
+		var columns []struct {
+			Name    string
+			Type    string
+			NotNull bool
+			Pk      int
+		}
+		err := db.Raw(`SELECT name, type, "notnull" AS not_null, pk FROM pragma_table_info(?) ORDER BY cid`, t.Name).
+			Scan(&columns).Error
+		if err != nil {
+			return Schema{}, fmt.Errorf("unable to read the columns of %s: %w", t.Name, err)
+		}
+		pk := map[int]string{}
+		for _, c := range columns {
+			t.Columns = append(t.Columns, Column{Name: c.Name, Type: normalizeType(c.Type, nil), Nullable: !c.NotNull})
+			if c.Pk > 0 {
+				pk[c.Pk] = c.Name
+			}
+		}
+		for i := 1; i <= len(pk); i++ {
+			t.PrimaryKey = append(t.PrimaryKey, pk[i])
+		}
+	}
+
+	for i := range s.Tables {
+		t := &s.Tables[i]
+		var rows []struct {
+			ConstraintName string
+			ColumnName     string
+			RefTable       string
+			RefColumn      string
+			// Seq is the position of the column in its foreign key
+			Seq int
+		}
+		err := db.Raw(`SELECT id AS constraint_name, "from" AS column_name, "table" AS ref_table, "to" AS ref_column, seq
+FROM pragma_foreign_key_list(?) ORDER BY id, seq`, t.Name).
+			Scan(&rows).Error
+		if err != nil {
+			return Schema{}, fmt.Errorf("unable to read the foreign keys of %s: %w", t.Name, err)
+		}
+		fkColumns := make([]foreignKeyColumn, 0, len(rows))
+		for _, r := range rows {
+			c := foreignKeyColumn{TableName: t.Name, ConstraintName: r.ConstraintName, ColumnName: r.ColumnName,
+				RefTable: r.RefTable, RefColumn: r.RefColumn}
+			// the referenced column is empty when the foreign key references the primary key
+			if ref, ok := byName[c.RefTable]; ok && c.RefColumn == "" && r.Seq < len(ref.PrimaryKey) {
+				c.RefColumn = ref.PrimaryKey[r.Seq]
+			}
+			fkColumns = append(fkColumns, c)
+		}
+		addForeignKeys(byName, fkColumns)
+	}
+	return s, nil
+}
+
+// inspectIndexes returns the indexes of the table sorted by name, using the gorm migrator of the engine
+func inspectIndexes(db *gorm.DB, table string) ([]Index, error) {
+	// the sqlite migrator logs the index queries in debug mode, errors are returned anyway
+	indexes, err := db.Session(&gorm.Session{Logger: logger.Discard}).Migrator().GetIndexes(table)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the indexes of %s: %w", table, err)
+	}
+	var result []Index
+	for _, idx := range indexes {
+		if pk, _ := idx.PrimaryKey(); pk {
+			continue
+		}
+		i := Index{Name: idx.Name(), Columns: idx.Columns()}
+		i.Unique, _ = idx.Unique()
+		result = append(result, i)
+	}
+	slices.SortFunc(result, func(a, b Index) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return result, nil
+}
+
+// normalizeType returns the engine-neutral name of a column type, length is used if the type has none
+func normalizeType(typ string, length *int64) string {
+	typ = strings.ToLower(strings.TrimSpace(typ))
+	base, args := typ, ""
+	if start := strings.IndexByte(typ, '('); start != -1 {
+		base = strings.TrimSpace(typ[:start])
+		if end := strings.IndexByte(typ[start:], ')'); end != -1 {
+			args = typ[start+1 : start+end]
+		}
+	}
+	// e.g. bigint unsigned
+	base = strings.TrimSuffix(base, " unsigned")
+	if args == "" && length != nil && *length > 0 {
+		args = strconv.FormatInt(*length, 10)
+	}
+
+	switch base {
+	case "tinyint":
+		if args == "1" {
+			return "boolean"
+		}
+		return "smallint"
+	case "bool", "boolean", "bit":
+		return "boolean"
+	case "smallint", "int2":
+		return "smallint"
+	case "int", "integer", "int4", "mediumint", "serial":
+		return "integer"
+	case "bigint", "int8", "bigserial":
+		return "bigint"
+	case "real", "float4":
+		return "real"
+	case "double", "double precision", "float", "float8":
+		return "double"
+	case "numeric", "decimal":
+		return "numeric"
+	case "varchar", "character varying", "nvarchar":
+		if args == "" || args == "-1" || args == "max" {
+			return "text"
+		}
+		return "varchar(" + args + ")"
+	case "char", "character", "nchar", "bpchar":
+		if args == "" {
+			return "char"
+		}
+		return "char(" + args + ")"
+	case "text", "tinytext", "mediumtext", "longtext", "ntext", "clob":
+		return "text"
+	case "timestamp", "timestamptz", "timestamp without time zone", "timestamp with time zone",
+		"datetime", "datetime2", "datetimeoffset", "smalldatetime":
+		return "timestamp"
+	case "time", "time without time zone", "time with time zone":
+		return "time"
+	case "blob", "tinyblob", "mediumblob", "longblob", "bytea", "binary", "varbinary":
+		return "blob"
+	case "json", "jsonb":
+		return "json"
+	}
+	return typ
+}
+
+// Diff returns the differences of got from want as human-readable lines, it is empty if the schemas are equal
+func Diff(want, got Schema) []string {
+	var diffs []string
+	for _, wt := range want.Tables {
+		gt, ok := got.Table(wt.Name)
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("table %s is missing", wt.Name))
+			continue
+		}
+		diffs = append(diffs, diffTable(wt, gt)...)
+	}
+	for _, gt := range got.Tables {
+		if _, ok := want.Table(gt.Name); !ok {
+			diffs = append(diffs, fmt.Sprintf("table %s is unexpected", gt.Name))
+		}
+	}
+	return diffs
+}
+
+// diffTable returns the differences of two tables with the same name
+func diffTable(want, got Table) []string {
+	var diffs []string
+	add := func(format string, args ...any) {
+		diffs = append(diffs, fmt.Sprintf("table %s: ", want.Name)+fmt.Sprintf(format, args...))
+	}
+
+	for _, wc := range want.Columns {
+		idx := slices.IndexFunc(got.Columns, func(c Column) bool { return c.Name == wc.Name })
+		if idx == -1 {
+			add("column %s is missing", wc.Name)
+			continue
+		}
+		gc := got.Columns[idx]
+		if gc.Type != wc.Type {
+			add("column %s has type %s, want %s", wc.Name, gc.Type, wc.Type)
+		}
+		if gc.Nullable != wc.Nullable {
+			add("column %s has nullable %t, want %t", wc.Name, gc.Nullable, wc.Nullable)
+		}
+	}
+	for _, gc := range got.Columns {
+		if !slices.ContainsFunc(want.Columns, func(c Column) bool { return c.Name == gc.Name }) {
+			add("column %s is unexpected", gc.Name)
+		}
+	}
+
+	if !slices.Equal(want.PrimaryKey, got.PrimaryKey) {
+		add("primary key is (%s), want (%s)", strings.Join(got.PrimaryKey, ", "), strings.Join(want.PrimaryKey, ", "))
+	}
+
+	for _, wi := range want.Indexes {
+		idx := slices.IndexFunc(got.Indexes, func(i Index) bool { return i.Name == wi.Name })
+		if idx == -1 {
+			add("index %s is missing", wi.Name)
+			continue
+		}
+		gi := got.Indexes[idx]
+		if !slices.Equal(gi.Columns, wi.Columns) || gi.Unique != wi.Unique {
+			add("index %s is %s, want %s", wi.Name, gi, wi)
+		}
+	}
+	for _, gi := range got.Indexes {
+		if !slices.ContainsFunc(want.Indexes, func(i Index) bool { return i.Name == gi.Name }) {
+			add("index %s is unexpected", gi.Name)
+		}
+	}
+
+	for _, wf := range want.ForeignKeys {
+		if !slices.ContainsFunc(got.ForeignKeys, wf.equal) {
+			add("foreign key %s is missing", wf)
+		}
+	}
+	for _, gf := range got.ForeignKeys {
+		if !slices.ContainsFunc(want.ForeignKeys, gf.equal) {
+			add("foreign key %s is unexpected", gf)
+		}
+	}
+	return diffs
+}
+
+func (i Index) String() string {
+	if i.Unique {
+		return fmt.Sprintf("unique (%s)", strings.Join(i.Columns, ", "))
+	}
+	return fmt.Sprintf("(%s)", strings.Join(i.Columns, ", "))
+}
+
+func (f ForeignKey) String() string {
+	return fmt.Sprintf("(%s) references %s (%s)",
+		strings.Join(f.Columns, ", "), f.ReferencedTable, strings.Join(f.ReferencedColumns, ", "))
+}
+
+func (f ForeignKey) equal(other ForeignKey) bool {
+	return f.ReferencedTable == other.ReferencedTable &&
+		slices.Equal(f.Columns, other.Columns) &&
+		slices.Equal(f.ReferencedColumns, other.ReferencedColumns)
+}
@@ -0,0 +1,77 @@
+package testdbs
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// resetSelection clears the DB selection of the flags and env for the test, the flags of the
+// test run and the initialized DBs are restored afterward
+func resetSelection(t *testing.T) {
+	t.Helper()
+	selection, all, dbs := *selectDbsFlag, *runAllDbs, targetDBS
+	t.Cleanup(func() {
+		*selectDbsFlag, *runAllDbs, targetDBS = selection, all, dbs
+	})
+	*selectDbsFlag, *runAllDbs = "", false
+	t.Setenv(SelectDBsEnv, "")
+	// the env runs all the DBs when set, t.Setenv restores it
+	t.Setenv(RunAllDBsEnv, "")
+	if err := os.Unsetenv(RunAllDBsEnv); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSelectDbs(t *testing.T) {
+	resetSelection(t)
+	fast := []TargetDb{&SqliteNoCgo{}}
+	long := []TargetDb{&SqliteCgo{}, NewPostgres()}
+
+	dbs, err := selectDbs(fast, long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dbs) != 1 || dbs[0] != fast[0] {
+		t.Errorf("expected only the fast DBs, got %v", dbs)
+	}
+
+	t.Setenv(SelectDBsEnv, "postgres, SQLITEWITHCGO")
+	dbs, err = selectDbs(fast, long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dbs) != 2 || dbs[0] != long[1] || dbs[1] != long[0] {
+		t.Errorf("expected the selected DBs in order, got %v", dbs)
+	}
+
+	*selectDbsFlag = "sqlitenocgo"
+	dbs, err = selectDbs(fast, long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dbs) != 1 || dbs[0] != fast[0] {
+		t.Errorf("expected the flag to take precedence over the env, got %v", dbs)
+	}
+}
+
+func TestSelectUnknownDb(t *testing.T) {
+	resetSelection(t)
+	t.Setenv(SelectDBsEnv, "sqlitenocgo, unknown")
+	_, err := selectDbs([]TargetDb{&SqliteNoCgo{}}, nil)
+	if !errors.Is(err, ErrUnknownDbType) {
+		t.Errorf("expected ErrUnknownDbType, got: %v", err)
+	}
+}
+
+func TestDuplicatedDbTypes(t *testing.T) {
+	resetSelection(t)
+	initialized := len(targetDBS)
+	err := InitCustomDbsE([]TargetDb{NewPostgres(), NewPostgres()}, nil)
+	if err == nil {
+		t.Error("expected an error for duplicated db types")
+	}
+	if len(targetDBS) != initialized {
+		t.Error("expected no DBs to be initialized")
+	}
+}
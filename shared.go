@@ -0,0 +1,197 @@
+package testdbs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/docker/go-connections/nat"
+	"github.com/hashicorp/go-multierror"
+	"github.com/shirou/gopsutil/v3/process"
+	"github.com/testcontainers/testcontainers-go"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	SharedContainersEnv = "TESTDBS_SHARED"
+	// sharedLockTimeout is the max time to wait for the registry lock, it includes starting the container
+	sharedLockTimeout = 5 * time.Minute
+)
+
+func sharedContainers() bool {
+	_, shared := os.LookupEnv(SharedContainersEnv)
+	return shared
+}
+
+// sharedContainer is a container used by the test binaries of several packages, e.g. when running go test ./...
+// The processes using it are tracked in a registry file in the temp dir guarded by a lock file,
+// the last one to release it terminates the container. Processes that no longer exist are reaped.
+type sharedContainer struct {
+	container testcontainers.Container
+	registry  string
+}
+
+// startSharedContainer attaches to the container named after the request, starting it if needed
+func startSharedContainer(ctx context.Context, req testcontainers.ContainerRequest) (*sharedContainer, error) {
+	name := sharedContainerName(req)
+	dir := filepath.Join(os.TempDir(), testDbDir+"_shared")
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("%w: unable to create shared containers dir: %w", ErrContainerStart, err)
+	}
+	registry := filepath.Join(dir, name+".json")
+
+	unlock, err := lockFile(registry + ".lock")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrContainerStart, err)
+	}
+	defer func() {
+		_ = unlock()
+	}()
+
+	req.Name = name
+	ctr, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+		Reuse:            true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to start shared container %s: %w", ErrContainerStart, name, err)
+	}
+
+	pids, err := readPids(registry)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrContainerStart, err)
+	}
+	pids = append(livePids(pids), os.Getpid())
+	if err := writePids(registry, pids); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrContainerStart, err)
+	}
+	return &sharedContainer{container: ctr, registry: registry}, nil
+}
+
+// sharedContainerName returns the name of the container, it includes a hash of the configuration
+// so that DBs configured differently don't share a container.
+func sharedContainerName(req testcontainers.ContainerRequest) string {
+	h := fnv.New32a()
+	_, _ = fmt.Fprintf(h, "%s|%v|%v", req.Image, req.Env, req.Cmd)
+	return fmt.Sprintf("%s_%s_%08x", testDbDir, normalizeDbName(req.Image), h.Sum32())
+}
+
+// address returns the host and mapped port of the container
+func (s *sharedContainer) address(ctx context.Context, port nat.Port) (string, string, error) {
+	host, err := s.container.Host(ctx)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: failed to get shared container host: %w", ErrContainerStart, err)
+	}
+	mapped, err := s.container.MappedPort(ctx, port)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: failed to get shared container port: %w", ErrContainerStart, err)
+	}
+	return host, mapped.Port(), nil
+}
+
+// release removes the current process from the registry and terminates the container if no other process uses it
+func (s *sharedContainer) release() (err error) {
+	unlock, err := lockFile(s.registry + ".lock")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if uErr := unlock(); uErr != nil {
+			err = multierror.Append(err, uErr)
+		}
+	}()
+
+	pids, err := readPids(s.registry)
+	if err != nil {
+		return err
+	}
+	pids = slices.DeleteFunc(livePids(pids), func(pid int) bool {
+		return pid == os.Getpid()
+	})
+	if len(pids) > 0 {
+		return writePids(s.registry, pids)
+	}
+
+	if err := os.Remove(s.registry); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to delete shared container registry: %w", err)
+	}
+	if err := testcontainers.TerminateContainer(s.container); err != nil {
+		return fmt.Errorf("failed to terminate shared container: %w", err)
+	}
+	return nil
+}
+
+// lockFile takes an exclusive lock on the file, waiting for it to be released by other processes.
+// The lock is held by the OS on the open file, so it is released when a process exits without unlocking
+// and the file is never removed, removing it would let two processes lock different files with the same path.
+func lockFile(path string) (func() error, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open lock file: %w", err)
+	}
+	deadline := time.Now().Add(sharedLockTimeout)
+	for {
+		locked, err := tryLock(f)
+		if err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("unable to lock file %s: %w", path, err)
+		}
+		if locked {
+			return func() error {
+				err := unlock(f)
+				if cErr := f.Close(); err == nil {
+					err = cErr
+				}
+				return err
+			}, nil
+		}
+		if time.Now().After(deadline) {
+			_ = f.Close()
+			return nil, fmt.Errorf("timeout waiting for lock file %s", path)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// readPids returns the processes stored in the registry, a missing registry is empty
+func readPids(registry string) ([]int, error) {
+	content, err := os.ReadFile(registry)
+	if errors.Is(err, os.ErrNotExist) {
+		return []int{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read shared container registry: %w", err)
+	}
+	pids := []int{}
+	if err := json.Unmarshal(content, &pids); err != nil {
+		return nil, fmt.Errorf("unable to parse shared container registry: %w", err)
+	}
+	return pids, nil
+}
+
+func writePids(registry string, pids []int) error {
+	content, err := json.Marshal(pids)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(registry, content, 0640); err != nil {
+		return fmt.Errorf("unable to write shared container registry: %w", err)
+	}
+	return nil
+}
+
+// livePids returns the processes that still exist
+func livePids(pids []int) []int {
+	live := []int{}
+	for _, pid := range pids {
+		if exists, err := process.PidExists(int32(pid)); err == nil && exists {
+			live = append(live, pid)
+		}
+	}
+	return live
+}
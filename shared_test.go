@@ -0,0 +1,48 @@
+package testdbs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.lock")
+	unlock, err := lockFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locked := make(chan func() error)
+	go func() {
+		unlock2, err := lockFile(path)
+		if err != nil {
+			t.Error(err)
+			unlock2 = nil
+		}
+		locked <- unlock2
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("expected the second lock to wait for the first one")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+	unlock2 := <-locked
+	if unlock2 == nil {
+		t.FailNow()
+	}
+	if err := unlock2(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the lock file is kept, so later processes lock the same file
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected the lock file to be kept: %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+// Package snapshot asserts the state of a database against a golden file, the tables are dumped to an
+// engine-neutral JSON so that the same golden file is used for all the DBs of testdbs.
+//
+// Run the tests with the flag -snapshot.update to write the golden files instead of comparing them.
+package snapshot
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/go-cmp/cmp"
+	"gorm.io/gorm"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Flag to write the golden files instead of comparing them
+var update *bool
+
+func init() {
+	update = flag.Bool("snapshot.update", false, "update the golden files of the snapshot assertions")
+}
+
+// Tables holds the rows of every dumped table, the rows are maps of column name to value
+type Tables map[string][]map[string]any
+
+// Assert dumps the tables of db and compares them with the golden file testdata/<name>.json,
+// differences are reported with t.Errorf. With the -snapshot.update flag the golden file is written instead.
+func Assert(t testing.TB, db *gorm.DB, name string, tables ...string) {
+	t.Helper()
+	got, err := Dump(db, tables...)
+	if err != nil {
+		t.Fatalf("unable to dump tables: %v", err)
+	}
+	content, err := got.JSON()
+	if err != nil {
+		t.Fatalf("unable to encode tables: %v", err)
+	}
+
+	path := filepath.Join("testdata", name+".json")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatalf("unable to create golden file dir: %v", err)
+		}
+		if err := os.WriteFile(path, content, 0640); err != nil {
+			t.Fatalf("unable to write golden file: %v", err)
+		}
+		return
+	}
+
+	golden, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("golden file %s does not exist, run the test with -snapshot.update to create it", path)
+	}
+	if err != nil {
+		t.Fatalf("unable to read golden file: %v", err)
+	}
+
+	var want, gotDecoded any
+	if err := json.Unmarshal(golden, &want); err != nil {
+		t.Fatalf("unable to decode golden file %s: %v", path, err)
+	}
+	// the dump is decoded again so that both sides hold the same JSON types
+	if err := json.Unmarshal(content, &gotDecoded); err != nil {
+		t.Fatalf("unable to decode tables: %v", err)
+	}
+	if diff := cmp.Diff(want, gotDecoded); diff != "" {
+		t.Errorf("database does not match golden file %s (-want +got):\n%s", path, diff)
+	}
+}
+
+// Dump returns the rows of the tables ordered by primary key, or by all the columns if the table has none,
+// with values normalized to be the same on all engines, if no table is passed all tables are dumped.
+// Integers and booleans become int64, byte slices become strings and times are UTC with millisecond precision.
+func Dump(db *gorm.DB, tables ...string) (Tables, error) {
+	if len(tables) == 0 {
+		var err error
+		tables, err = db.Migrator().GetTables()
+		if err != nil {
+			return nil, fmt.Errorf("unable to list tables: %w", err)
+		}
+	}
+
+	dump := Tables{}
+	for _, table := range tables {
+		rows, err := dumpTable(db, table)
+		if err != nil {
+			return nil, err
+		}
+		dump[table] = rows
+	}
+	return dump, nil
+}
+
+// JSON returns the indented JSON of the tables, map keys are sorted so the output is deterministic
+func (t Tables) JSON() ([]byte, error) {
+	b, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// dumpTable returns the normalized rows of the table sorted by primary key
+func dumpTable(db *gorm.DB, table string) ([]map[string]any, error) {
+	columnTypes, err := db.Migrator().ColumnTypes(table)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the columns of %s: %w", table, err)
+	}
+	var pk []string
+	for _, ct := range columnTypes {
+		if isPk, ok := ct.PrimaryKey(); ok && isPk {
+			pk = append(pk, ct.Name())
+		}
+	}
+
+	var rows []map[string]any
+	if err := db.Table(table).Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("unable to read the rows of %s: %w", table, err)
+	}
+	for _, row := range rows {
+		for col, val := range row {
+			row[col] = normalize(val)
+		}
+	}
+
+	slices.SortStableFunc(rows, func(a, b map[string]any) int {
+		if len(pk) == 0 {
+			return strings.Compare(rowKey(a), rowKey(b))
+		}
+		for _, col := range pk {
+			if c := compare(a[col], b[col]); c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+	return rows, nil
+}
+
+// normalize converts the values returned by the drivers to the same type on all the engines
+func normalize(val any) any {
+	switch v := val.(type) {
+	case []byte:
+		return string(v)
+	case bool:
+		if v {
+			return int64(1)
+		}
+		return int64(0)
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint:
+		return int64(v)
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return int64(v)
+	case float32:
+		return float64(v)
+	case time.Time:
+		return v.UTC().Truncate(time.Millisecond).Format("2006-01-02T15:04:05.000Z07:00")
+	case *time.Time:
+		if v == nil {
+			return nil
+		}
+		return normalize(*v)
+	}
+	return val
+}
+
+// compare orders two normalized values, numbers by value and the rest by their text
+func compare(a, b any) int {
+	ai, aOk := a.(int64)
+	bi, bOk := b.(int64)
+	if aOk && bOk {
+		switch {
+		case ai < bi:
+			return -1
+		case ai > bi:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// rowKey returns the JSON of the row, used to order the rows of tables without primary key
+func rowKey(row map[string]any) string {
+	b, _ := json.Marshal(row)
+	return string(b)
+}
@@ -0,0 +1,89 @@
+package snapshot_test
+
+import (
+	"flag"
+	"github.com/go-bumbu/testdbs"
+	"github.com/go-bumbu/testdbs/internal/tbtest"
+	"github.com/go-bumbu/testdbs/snapshot"
+	"gorm.io/gorm"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// packages often define their own -update flag for golden files, importing snapshot must not redefine it
+var _ = flag.Bool("update", false, "update the golden files of the package")
+
+func TestMain(m *testing.M) {
+	testdbs.InitDBS()
+	code := m.Run()
+	err := testdbs.Clean()
+	if err != nil {
+		os.Exit(1)
+	}
+	os.Exit(code)
+}
+
+type Item struct {
+	ID        uint `gorm:"primaryKey"`
+	Name      string
+	Price     float64
+	Active    bool
+	CreatedAt time.Time
+}
+
+type Tag struct {
+	Name  string
+	Color string
+}
+
+// seed returns a database of the test with items and tags
+func seed(t *testing.T, dbt testdbs.TargetDb) *gorm.DB {
+	t.Helper()
+	db := testdbs.ForTest(t, dbt)
+	if err := db.AutoMigrate(&Item{}, &Tag{}); err != nil {
+		t.Fatalf("error in automigrate: %s", err)
+	}
+	created := time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC)
+	// inserted out of order, the dump is sorted by primary key
+	items := []Item{
+		{ID: 3, Name: "gamma", Price: 3.5, Active: true, CreatedAt: created},
+		{ID: 1, Name: "alpha", Price: 1, Active: false, CreatedAt: created},
+		{ID: 2, Name: "beta", Price: 2.25, Active: true, CreatedAt: created.Add(time.Hour)},
+	}
+	if err := db.Create(&items).Error; err != nil {
+		t.Fatalf("unable to create items: %v", err)
+	}
+	tags := []Tag{{Name: "b", Color: "red"}, {Name: "a", Color: "blue"}}
+	if err := db.Create(&tags).Error; err != nil {
+		t.Fatalf("unable to create tags: %v", err)
+	}
+	return db
+}
+
+func TestAssert(t *testing.T) {
+	for _, dbt := range testdbs.DBs() {
+		t.Run(dbt.DbType(), func(t *testing.T) {
+			db := seed(t, dbt)
+			snapshot.Assert(t, db, "items", "items", "tags")
+		})
+	}
+}
+
+func TestAssertMismatch(t *testing.T) {
+	dbt := testdbs.DBs()[0]
+	db := seed(t, dbt)
+	if err := db.Model(&Item{}).Where("id = ?", 2).Update("name", "changed").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tb := tbtest.New(t)
+	snapshot.Assert(tb, db, "items", "items", "tags")
+	if len(tb.Errors) != 1 {
+		t.Fatalf("expected one error, got %d", len(tb.Errors))
+	}
+	if !strings.Contains(tb.Errors[0], `"changed"`) {
+		t.Errorf("expected the diff to show the changed value, got: %s", tb.Errors[0])
+	}
+}
@@ -9,12 +9,14 @@ import (
 	sqlitecgo "gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
 // return the path for a db name
-func dbPath(name, suffix, tmpdir string, isLocal bool) string {
+func dbPath(name, suffix, tmpdir string, isLocal bool) (string, error) {
 	if name == "" {
 		name = defaultDbName
 	}
@@ -27,23 +29,23 @@ func dbPath(name, suffix, tmpdir string, isLocal bool) string {
 		} else if errors.Is(err, os.ErrNotExist) {
 			err = os.Mkdir(tmpdir, 0750)
 			if err != nil {
-				panic(fmt.Sprintf("error creating local temporary directory: %v", err))
+				return "", fmt.Errorf("error creating local temporary directory: %w", err)
 			}
 		} else {
-			panic(fmt.Sprintf("error while doing stat on dbfile: %v", err))
+			return "", fmt.Errorf("error while doing stat on dbfile: %w", err)
 		}
 	}
-	return fmt.Sprintf("%s/%s", tmpdir, dbName)
+	return fmt.Sprintf("%s/%s", tmpdir, dbName), nil
 }
 
 const testDbDir = "testdbs"
 
-func mkTmpDir() string {
+func mkTmpDir() (string, error) {
 	dir, err := os.MkdirTemp("", testDbDir+"_sqlite")
 	if err != nil {
-		panic(fmt.Sprintf("error creating temporary directory: %v", err))
+		return "", fmt.Errorf("error creating temporary directory: %w", err)
 	}
-	return dir
+	return dir, nil
 }
 
 // Add a flag to run sqlite on the local dir instead of on the tmpdir
@@ -65,70 +67,125 @@ func sqliteLocal() bool {
 }
 
 // ===============================================================================
-// Sqlite without CGO
+// Common sqlite implementation
 // ===============================================================================
 
-const (
-	noCgoSqliteSuffix = "no_CGO"
-	DBTypeSqliteNOCgo = "SqliteNoCgo"
-)
-
-type SqliteNoCgo struct {
-	dir     string
-	isLocal bool
-	logger  logger.Interface
-	pool    map[string]*gorm.DB
+// sqliteDb holds the logic shared by both sqlite drivers, the drivers only differ
+// in the gorm dialector used to open the file and the suffix of the db files.
+type sqliteDb struct {
+	dir       string
+	isLocal   bool
+	logger    logger.Interface
+	pool      connPool
+	templates templateSet
+	suffix    string
+	open      func(dsn string) gorm.Dialector
+	// memory DBs don't use files, a database exists as long as the pool holds its connection
+	memory bool
 }
 
-func (c *SqliteNoCgo) DbType() string {
-	return DBTypeSqliteNOCgo
-}
-func (c *SqliteNoCgo) Init(logger logger.Interface) {
+func (c *sqliteDb) init(logger logger.Interface, suffix string, open func(dsn string) gorm.Dialector) error {
 	c.logger = logger
-	c.pool = map[string]*gorm.DB{}
+	c.suffix = suffix
+	c.open = open
+	if c.memory {
+		return nil
+	}
 
 	_, localSqliteEnv := os.LookupEnv(LocalSqliteEnv)
 	if localSqliteEnv || sqliteLocal() {
 		c.isLocal = true
 		c.dir = "./"
 	} else {
-		c.dir = mkTmpDir()
+		dir, err := mkTmpDir()
+		if err != nil {
+			return err
+		}
+		c.dir = dir
 		c.isLocal = false
 	}
+	return nil
 }
-func (c *SqliteNoCgo) Conn() *gorm.DB {
+
+func (c *sqliteDb) Conn() *gorm.DB {
 	return c.ConnDbName(defaultDbName)
 }
 
-func (c *SqliteNoCgo) ConnDbName(name string) *gorm.DB {
+func (c *sqliteDb) ConnE() (*gorm.DB, error) {
+	return c.ConnDbNameE(defaultDbName)
+}
+
+func (c *sqliteDb) ConnDbName(name string) *gorm.DB {
+	db, err := c.ConnDbNameE(name)
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+func (c *sqliteDb) ConnDbNameE(name string) (*gorm.DB, error) {
 	name = normalizeDbName(name)
-	dbConn, exists := c.pool[name]
-	if exists {
-		return dbConn
+	return c.pool.getOrCreate(name, func() (*gorm.DB, error) {
+		return c.create(name)
+	})
+}
+
+// create opens a new database file, removing any leftover file with the same name
+func (c *sqliteDb) create(name string) (*gorm.DB, error) {
+	if c.memory {
+		return c.createMemory(name)
+	}
+	dbFile, err := dbPath(name, c.suffix, c.dir, c.isLocal)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDbCreate, err)
 	}
-	dbFile := dbPath(name, noCgoSqliteSuffix, c.dir, c.isLocal)
 	if _, err := os.Stat(dbFile); err == nil {
 		err = os.RemoveAll(dbFile)
 		if err != nil {
-			panic(fmt.Sprintf("error while removing dbfile: %v", err))
+			return nil, fmt.Errorf("%w: error while removing dbfile: %w", ErrDbCreate, err)
 		}
 	} else if !errors.Is(err, os.ErrNotExist) {
-		panic(fmt.Sprintf(" while doing stat on dbfile: %v", err))
+		return nil, fmt.Errorf("%w: error while doing stat on dbfile: %w", ErrDbCreate, err)
 	}
 
-	db, err := gorm.Open(sqliteNoCgo.Open(dbFile), &gorm.Config{
+	return c.openFile(dbFile)
+}
+
+// createMemory opens a named in-memory database with a shared cache, the connections are never closed
+// by the pool since the database is deleted once its last connection is closed.
+func (c *sqliteDb) createMemory(name string) (*gorm.DB, error) {
+	dsn := fmt.Sprintf("file:%s.%s?mode=memory&cache=shared", name, c.suffix)
+	db, err := c.openFile(dsn)
+	if err != nil {
+		return nil, err
+	}
+	sqlDb, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to get underlying DB: %w", ErrConnection, err)
+	}
+	sqlDb.SetMaxIdleConns(1)
+	sqlDb.SetConnMaxIdleTime(0)
+	sqlDb.SetConnMaxLifetime(0)
+	return db, nil
+}
+
+// openFile returns a gorm connection to the database file
+func (c *sqliteDb) openFile(dbFile string) (*gorm.DB, error) {
+	db, err := gorm.Open(c.open(dbFile), &gorm.Config{
 		Logger: c.logger,
 	})
 	if err != nil {
-		panic(fmt.Sprintf("failed to open test database: %v", err))
+		return nil, fmt.Errorf("%w: failed to open test database: %w", ErrConnection, err)
 	}
-	c.pool[name] = db
-	return db
+	registerRecorder(db)
+	return db, nil
 }
 
-func (c *SqliteNoCgo) Close(name string) error {
-
-	dbConn, exists := c.pool[name]
+// Close closes the connection to the database and deletes its files, the other databases are kept.
+// The files of local DBs are kept to inspect them after the tests.
+func (c *sqliteDb) Close(name string) error {
+	name = normalizeDbName(name)
+	dbConn, exists := c.pool.remove(name)
 	if !exists {
 		return fmt.Errorf("db connection with name %s not found", name)
 	}
@@ -142,30 +199,195 @@ func (c *SqliteNoCgo) Close(name string) error {
 		return err
 	}
 
-	if !c.isLocal {
-		if !strings.Contains(c.dir, testDbDir) {
-			panic("refusing to delete the dir since it does not seem to be from testdbs")
+	if c.isLocal {
+		return nil
+	}
+	return c.removeFiles(name)
+}
+
+// closeConn closes the connection to the database keeping its file
+func (c *sqliteDb) closeConn(name string) error {
+	dbConn, exists := c.pool.remove(name)
+	if !exists {
+		return nil
+	}
+	db, err := dbConn.DB()
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// Drop closes the connection to the database and deletes its files
+func (c *sqliteDb) Drop(name string) error {
+	name = normalizeDbName(name)
+	if err := c.closeConn(name); err != nil {
+		return err
+	}
+	return c.removeFiles(name)
+}
+
+// sqliteFileSuffixes are the files sqlite creates next to the database file
+var sqliteFileSuffixes = []string{"", "-wal", "-shm", "-journal"}
+
+// removeFiles deletes the database file and the journal files next to it
+func (c *sqliteDb) removeFiles(name string) error {
+	if c.memory {
+		return nil
+	}
+	dbFile, err := dbPath(name, c.suffix, c.dir, c.isLocal)
+	if err != nil {
+		return err
+	}
+	for _, suffix := range sqliteFileSuffixes {
+		err = os.Remove(dbFile + suffix)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("unable to delete db file: %w", err)
 		}
-		err = os.RemoveAll(c.dir)
+	}
+	return nil
+}
+
+// keep closes the connection to the database and moves its file to dir
+func (c *sqliteDb) keep(name, dir string) (string, error) {
+	if c.memory {
+		return "", fmt.Errorf("%w: in-memory sqlite databases can't be kept", errors.ErrUnsupported)
+	}
+	name = normalizeDbName(name)
+	if err := c.closeConn(name); err != nil {
+		return "", err
+	}
+	dbFile, err := dbPath(name, c.suffix, c.dir, c.isLocal)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, filepath.Base(dbFile))
+	if err := os.Rename(dbFile, path); err != nil {
+		// the temporary dir can be on a different device
+		if err := copyFile(dbFile, path); err != nil {
+			return "", fmt.Errorf("unable to move db file: %w", err)
+		}
+	}
+	return path, nil
+}
+
+// PrepareTemplate creates the template database and calls prepare with a connection to it,
+// the connection is closed afterward so that the database file is complete before being copied.
+func (c *sqliteDb) PrepareTemplate(name string, prepare func(db *gorm.DB) error) error {
+	if c.memory {
+		return fmt.Errorf("%w: in-memory sqlite does not support template databases", errors.ErrUnsupported)
+	}
+	name = normalizeDbName(name)
+	return c.templates.prepare(name, func() error {
+		db, err := c.ConnDbNameE(name)
 		if err != nil {
-			return fmt.Errorf("error cleaning up temporary directory: %w", err)
+			return err
+		}
+		err = prepare(db)
+		if cErr := c.closeConn(name); cErr != nil {
+			err = multierror.Append(err, cErr)
 		}
+		if err != nil {
+			return fmt.Errorf("unable to prepare template %s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+// ConnFromTemplate copies the template database file to newName and returns a connection to it
+func (c *sqliteDb) ConnFromTemplate(templateName, newName string) (*gorm.DB, error) {
+	if c.memory {
+		return nil, fmt.Errorf("%w: in-memory sqlite does not support template databases", errors.ErrUnsupported)
 	}
-	return nil
+	templateName = normalizeDbName(templateName)
+	newName = normalizeDbName(newName)
+	if !c.templates.ready(templateName) {
+		return nil, fmt.Errorf("%w: template %s was not prepared", ErrDbCreate, templateName)
+	}
+	return c.pool.getOrCreate(newName, func() (*gorm.DB, error) {
+		templateFile, err := dbPath(templateName, c.suffix, c.dir, c.isLocal)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrDbCreate, err)
+		}
+		dbFile, err := dbPath(newName, c.suffix, c.dir, c.isLocal)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrDbCreate, err)
+		}
+		if err := copyFile(templateFile, dbFile); err != nil {
+			return nil, fmt.Errorf("%w: unable to copy template %s: %w", ErrDbCreate, templateName, err)
+		}
+		return c.openFile(dbFile)
+	})
+}
+
+// copyFile copies the content of src into dst, dst is overwritten if it exists
+func copyFile(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cErr := out.Close(); cErr != nil && err == nil {
+			err = cErr
+		}
+	}()
+	_, err = io.Copy(out, in)
+	return err
 }
 
-func (c *SqliteNoCgo) CloseAll() error {
+// CloseAll closes all the connections and removes the temporary directory with all the database files
+func (c *sqliteDb) CloseAll() error {
 	var merr error
-	for name, _ := range c.pool {
-		err := c.Close(name)
+	for _, name := range c.pool.names() {
+		err := c.closeConn(name)
 		if err != nil {
 			merr = multierror.Append(merr, err)
 		}
+	}
 
+	if !c.isLocal && !c.memory {
+		if !strings.Contains(c.dir, testDbDir) {
+			return multierror.Append(merr, errors.New("refusing to delete the dir since it does not seem to be from testdbs"))
+		}
+		if err := os.RemoveAll(c.dir); err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("error cleaning up temporary directory: %w", err))
+		}
 	}
 	return merr
 }
 
+// ===============================================================================
+// Sqlite without CGO
+// ===============================================================================
+
+const (
+	noCgoSqliteSuffix = "no_CGO"
+	DBTypeSqliteNOCgo = "SqliteNoCgo"
+)
+
+type SqliteNoCgo struct {
+	sqliteDb
+}
+
+func (c *SqliteNoCgo) DbType() string {
+	return DBTypeSqliteNOCgo
+}
+func (c *SqliteNoCgo) Init(logger logger.Interface) {
+	if err := c.InitE(logger); err != nil {
+		panic(err)
+	}
+}
+
+func (c *SqliteNoCgo) InitE(logger logger.Interface) error {
+	return c.init(logger, noCgoSqliteSuffix, sqliteNoCgo.Open)
+}
+
 // ===============================================================================
 // Sqlite using CGO
 // ===============================================================================
@@ -176,94 +398,66 @@ const (
 )
 
 type SqliteCgo struct {
-	dir     string
-	isLocal bool
-	logger  logger.Interface
-	pool    map[string]*gorm.DB
+	sqliteDb
 }
 
 func (c *SqliteCgo) DbType() string {
 	return DBTypeSqliteCgo
 }
 func (c *SqliteCgo) Init(logger logger.Interface) {
-	c.logger = logger
-	c.pool = map[string]*gorm.DB{}
-
-	_, localSqliteEnv := os.LookupEnv(LocalSqliteEnv)
-	if localSqliteEnv || sqliteLocal() {
-		c.isLocal = true
-		c.dir = "./"
-	} else {
-		c.dir = mkTmpDir()
-		c.isLocal = false
+	if err := c.InitE(logger); err != nil {
+		panic(err)
 	}
 }
-func (c *SqliteCgo) Conn() *gorm.DB {
-	return c.ConnDbName(defaultDbName)
-}
 
-func (c *SqliteCgo) ConnDbName(name string) *gorm.DB {
-	name = normalizeDbName(name)
-	dbConn, exists := c.pool[name]
-	if exists {
-		return dbConn
-	}
-	dbFile := dbPath(name, CgoSqliteSuffix, c.dir, c.isLocal)
-	if _, err := os.Stat(dbFile); err == nil {
-		err = os.RemoveAll(dbFile)
-		if err != nil {
-			panic(fmt.Sprintf("error while removing dbfile: %v", err))
-		}
-	} else if !errors.Is(err, os.ErrNotExist) {
-		panic(fmt.Sprintf(" while doing stat on dbfile: %v", err))
-	}
-
-	db, err := gorm.Open(sqlitecgo.Open(dbFile), &gorm.Config{
-		Logger: c.logger,
-	})
-	if err != nil {
-		panic(fmt.Sprintf("failed to open test database: %v", err))
-	}
-	c.pool[name] = db
-	return db
+func (c *SqliteCgo) InitE(logger logger.Interface) error {
+	return c.init(logger, CgoSqliteSuffix, sqlitecgo.Open)
 }
 
-func (c *SqliteCgo) Close(name string) error {
+// ===============================================================================
+// In-memory sqlite
+// ===============================================================================
 
-	dbConn, exists := c.pool[name]
-	if !exists {
-		return fmt.Errorf("db connection with name %s not found", name)
-	}
+const (
+	DBTypeSqliteNoCgoMemory = "SqliteNoCgoMemory"
+	DBTypeSqliteCgoMemory   = "SqliteWithCgoMemory"
+)
 
-	db, err := dbConn.DB()
-	if err != nil {
-		return err
-	}
-	err = db.Close()
-	if err != nil {
-		return err
-	}
+// SqliteNoCgoMemory keeps the databases in memory instead of in files, ConnDbName(name) returns
+// a named in-memory database that exists as long as its connection is not closed.
+type SqliteNoCgoMemory struct {
+	sqliteDb
+}
 
-	if !c.isLocal {
-		if !strings.Contains(c.dir, testDbDir) {
-			panic("refusing to delete the dir since it does not seem to be from testdbs")
-		}
-		err = os.RemoveAll(c.dir)
-		if err != nil {
-			return fmt.Errorf("error cleaning up temporary directory: %w", err)
-		}
+func (c *SqliteNoCgoMemory) DbType() string {
+	return DBTypeSqliteNoCgoMemory
+}
+func (c *SqliteNoCgoMemory) Init(logger logger.Interface) {
+	if err := c.InitE(logger); err != nil {
+		panic(err)
 	}
-	return nil
 }
 
-func (c *SqliteCgo) CloseAll() error {
-	var merr error
-	for name, _ := range c.pool {
-		err := c.Close(name)
-		if err != nil {
-			merr = multierror.Append(merr, err)
-		}
+func (c *SqliteNoCgoMemory) InitE(logger logger.Interface) error {
+	c.memory = true
+	return c.init(logger, noCgoSqliteSuffix, sqliteNoCgo.Open)
+}
 
+// SqliteCgoMemory is like SqliteNoCgoMemory but uses the CGO sqlite driver
+type SqliteCgoMemory struct {
+	sqliteDb
+}
+
+func (c *SqliteCgoMemory) DbType() string {
+	return DBTypeSqliteCgoMemory
+}
+func (c *SqliteCgoMemory) Init(logger logger.Interface) {
+	if err := c.InitE(logger); err != nil {
+		panic(err)
 	}
-	return merr
+}
+
+func (c *SqliteCgoMemory) InitE(logger logger.Interface) error {
+	c.memory = true
+	return c.init(logger, CgoSqliteSuffix, sqlitecgo.Open)
 }
@@ -0,0 +1,289 @@
+package testdbs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/hashicorp/go-multierror"
+	"github.com/testcontainers/testcontainers-go"
+	"gorm.io/driver/sqlserver"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	DBTypeSqlserver = "sqlserver"
+)
+
+type testDBSqlserver struct {
+	cfg     containerConfig
+	once    sync.Once
+	initErr error
+	logger  logger.Interface
+	host    string
+	port    string
+	pool    connPool
+	clean   func() error
+}
+
+// Close closes the connection to the database, the database is also dropped when configured with WithDropOnClose
+func (c *testDBSqlserver) Close(name string) error {
+	name = normalizeDbName(name)
+	if err := c.closeConn(name); err != nil {
+		return err
+	}
+	if c.cfg.dropOnClose {
+		return c.dropDatabase(name)
+	}
+	return nil
+}
+
+// closeConn closes the connection to the database keeping the database
+func (c *testDBSqlserver) closeConn(name string) error {
+	db, exists := c.pool.remove(name)
+	if !exists {
+		return fmt.Errorf("db connection with name %s not found", name)
+	}
+	under, err := db.DB()
+	if err != nil {
+		return fmt.Errorf("unable to get underlying DB: %w", err)
+	}
+	return under.Close()
+}
+
+func (c *testDBSqlserver) CloseAll() error {
+	var merr error
+	for _, name := range c.pool.names() {
+		err := c.closeConn(name)
+		if err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+	if c.clean != nil {
+		if err := c.clean(); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+	return merr
+}
+
+func (c *testDBSqlserver) DbType() string {
+	if c.cfg.label != "" {
+		return c.cfg.label
+	}
+	return DBTypeSqlserver
+}
+
+const (
+	// the sa password needs to satisfy the sql server password policy
+	sqlserverPassword = "Testdbs_Passw0rd"
+	sqlserverUser     = "sa"
+	sqlserverImage    = "mcr.microsoft.com/mssql/server:2022-latest"
+	// sqlserverAdminDb is the system database used to create and drop other databases
+	sqlserverAdminDb = "master"
+)
+
+// NewSqlserver returns a sql server TargetDb running in a container configured with the options,
+// the container runs the developer edition and accepts the EULA.
+// The container only sets the password of the sa user, so WithCredentials only accepts the user sa.
+// Unlike postgres and mysql it can't use an external DSN or a shared container, and its databases can't be kept.
+func NewSqlserver(opts ...Option) TargetDb {
+	c := &testDBSqlserver{}
+	for _, opt := range opts {
+		opt(&c.cfg)
+	}
+	return c
+}
+
+func (c *testDBSqlserver) Init(logger logger.Interface) {
+	if err := c.InitE(logger); err != nil {
+		panic(err)
+	}
+}
+
+func (c *testDBSqlserver) InitE(logger logger.Interface) error {
+	c.logger = logger
+	c.once.Do(func() {
+		c.cfg.withDefaults(containerConfig{
+			image:          sqlserverImage,
+			startupTimeout: 2 * time.Minute,
+			dbName:         defaultDbName,
+			user:           sqlserverUser,
+			password:       sqlserverPassword,
+		})
+		if c.cfg.user != sqlserverUser {
+			c.initErr = fmt.Errorf("sql server only supports the user %s, got %s", sqlserverUser, c.cfg.user)
+			return
+		}
+		c.initErr = c.start()
+	})
+	return c.initErr
+}
+
+// start runs the sql server container and creates the default DB,
+// if any step fails the container is terminated again.
+func (c *testDBSqlserver) start() (err error) {
+	ctx := context.Background()
+
+	sqlserverContainer, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: c.containerRequest(),
+		Started:          true,
+	})
+	cleanFn := func() error {
+		if err := testcontainers.TerminateContainer(sqlserverContainer); err != nil {
+			return fmt.Errorf("failed to terminate SQL Server container: %w", err)
+		}
+		return nil
+	}
+	defer func() {
+		if err != nil {
+			if cErr := cleanFn(); cErr != nil {
+				err = multierror.Append(err, cErr)
+			}
+		}
+	}()
+	if err != nil {
+		return fmt.Errorf("%w: failed to start SQL Server container: %w", ErrContainerStart, err)
+	}
+
+	host, err := sqlserverContainer.Host(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: failed to get SQL Server container host: %w", ErrContainerStart, err)
+	}
+	c.host = host
+
+	port, err := sqlserverContainer.MappedPort(ctx, "1433")
+	if err != nil {
+		return fmt.Errorf("%w: failed to get SQL Server container port: %w", ErrContainerStart, err)
+	}
+	c.port = port.Port()
+
+	// sql server has no env to create a database on startup
+	_, err = c.ConnDbNameE(c.cfg.dbName)
+	if err != nil {
+		return err
+	}
+	c.clean = cleanFn
+	return nil
+}
+
+// containerRequest returns the request to start the sql server container, the container is ready
+// once the user can log in since the port is opened before the server accepts connections.
+func (c *testDBSqlserver) containerRequest() testcontainers.ContainerRequest {
+	return testcontainers.ContainerRequest{
+		Image:        c.cfg.image,
+		ExposedPorts: []string{"1433/tcp"},
+		Env: c.cfg.containerEnv(map[string]string{
+			"ACCEPT_EULA":       "Y",
+			"MSSQL_PID":         "Developer",
+			"MSSQL_SA_PASSWORD": c.cfg.password,
+		}),
+		Cmd: c.cfg.cmd,
+		WaitingFor: waitForQuery("1433/tcp", "sqlserver", c.cfg.startupTimeout, func(host, port string) string {
+			return c.dsn(host, port, sqlserverAdminDb)
+		}),
+	}
+}
+
+// dsn returns the dsn to connect to the database name
+func (c *testDBSqlserver) dsn(host, port, name string) string {
+	u := url.URL{
+		Scheme:   "sqlserver",
+		User:     url.UserPassword(c.cfg.user, c.cfg.password),
+		Host:     net.JoinHostPort(host, port),
+		RawQuery: url.Values{"database": []string{name}}.Encode(),
+	}
+	return u.String()
+}
+
+// open returns a gorm connection to the database with the given name
+func (c *testDBSqlserver) open(name string) (*gorm.DB, error) {
+	db, err := gorm.Open(sqlserver.Open(c.dsn(c.host, c.port, name)), &gorm.Config{
+		Logger: c.logger,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to connect to SQL Server test database: %w", ErrConnection, err)
+	}
+	registerRecorder(db)
+	return db, nil
+}
+
+func (c *testDBSqlserver) Conn() *gorm.DB {
+	return c.ConnDbName(c.cfg.dbName)
+}
+
+func (c *testDBSqlserver) ConnE() (*gorm.DB, error) {
+	return c.ConnDbNameE(c.cfg.dbName)
+}
+
+func (c *testDBSqlserver) ConnDbName(name string) *gorm.DB {
+	db, err := c.ConnDbNameE(name)
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+func (c *testDBSqlserver) ConnDbNameE(name string) (*gorm.DB, error) {
+	name = normalizeDbName(name)
+	return c.pool.getOrCreate(name, func() (*gorm.DB, error) {
+		if err := c.createDb(name); err != nil {
+			return nil, err
+		}
+		return c.open(name)
+	})
+}
+
+// adminConn opens a connection to the master database, used to create and drop databases
+func (c *testDBSqlserver) adminConn() (*sql.DB, error) {
+	db, err := sql.Open("sqlserver", c.dsn(c.host, c.port, sqlserverAdminDb))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConnection, err)
+	}
+	return db, nil
+}
+
+// createDb creates a new database unless it already exists
+func (c *testDBSqlserver) createDb(name string) error {
+	db, err := c.adminConn()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec(fmt.Sprintf("IF DB_ID(N'%s') IS NULL CREATE DATABASE [%s]", name, name))
+	if err != nil {
+		return fmt.Errorf("%w: unable to create database %s: %w", ErrDbCreate, name, err)
+	}
+	return nil
+}
+
+// Drop closes the connection to the database and deletes it
+func (c *testDBSqlserver) Drop(name string) error {
+	name = normalizeDbName(name)
+	if _, exists := c.pool.get(name); exists {
+		if err := c.closeConn(name); err != nil {
+			return err
+		}
+	}
+	return c.dropDatabase(name)
+}
+
+// dropDatabase deletes the database with the given name, other sessions on the database are rolled back
+func (c *testDBSqlserver) dropDatabase(name string) error {
+	db, err := c.adminConn()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	query := fmt.Sprintf("IF DB_ID(N'%s') IS NOT NULL BEGIN "+
+		"ALTER DATABASE [%s] SET SINGLE_USER WITH ROLLBACK IMMEDIATE; DROP DATABASE [%s]; END", name, name, name)
+	_, err = db.Exec(query)
+	if err != nil {
+		return fmt.Errorf("unable to drop database %s: %w", name, err)
+	}
+	return nil
+}
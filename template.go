@@ -0,0 +1,65 @@
+package testdbs
+
+import (
+	"gorm.io/gorm"
+	"sync"
+)
+
+// TemplateDb is implemented by the DBs that can clone a prepared database, this avoids running
+// the same migrations for every test database.
+// A template should only be used through ConnFromTemplate, connecting to it with ConnDbName
+// can prevent or corrupt the copies.
+type TemplateDb interface {
+	// PrepareTemplate creates the template database and calls prepare with a connection to it,
+	// the template is only prepared once per name, subsequent calls return the first result.
+	PrepareTemplate(name string, prepare func(db *gorm.DB) error) error
+	// ConnFromTemplate creates a new database as a copy of the prepared template and returns a connection to it,
+	// like in ConnDbName the connection is reused if the database was already created.
+	ConnFromTemplate(templateName, newName string) (*gorm.DB, error)
+}
+
+// templateSet keeps track of the prepared templates of a TargetDb, it is safe for concurrent use.
+type templateSet struct {
+	mu        sync.Mutex
+	templates map[string]*template
+}
+
+type template struct {
+	done chan struct{}
+	err  error
+}
+
+// prepare calls fn only once per name and returns its result,
+// concurrent calls for the same name wait for the first one to complete.
+func (s *templateSet) prepare(name string, fn func() error) error {
+	s.mu.Lock()
+	if s.templates == nil {
+		s.templates = map[string]*template{}
+	}
+	t, exists := s.templates[name]
+	if !exists {
+		t = &template{done: make(chan struct{})}
+		s.templates[name] = t
+	}
+	s.mu.Unlock()
+
+	if exists {
+		<-t.done
+		return t.err
+	}
+	t.err = fn()
+	close(t.done)
+	return t.err
+}
+
+// ready returns true if the template with name was prepared without errors
+func (s *templateSet) ready(name string) bool {
+	s.mu.Lock()
+	t, exists := s.templates[name]
+	s.mu.Unlock()
+	if !exists {
+		return false
+	}
+	<-t.done
+	return t.err == nil
+}
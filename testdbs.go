@@ -1,79 +1,150 @@
 package testdbs
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"github.com/hashicorp/go-multierror"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
-	"log"
 	"os"
 	"regexp"
 	"slices"
 	"strings"
-	"time"
 )
 
 type TargetDb interface {
 	DbType() string
 	Init(logger logger.Interface)
+	// InitE is like Init but returns an error instead of panicking
+	InitE(logger logger.Interface) error
 	Conn() *gorm.DB
+	// ConnE is like Conn but returns an error instead of panicking
+	ConnE() (*gorm.DB, error)
 	ConnDbName(name string) *gorm.DB
+	// ConnDbNameE is like ConnDbName but returns an error instead of panicking
+	ConnDbNameE(name string) (*gorm.DB, error)
 	Close(name string) error
+	// Drop closes the connection to the database and deletes the database
+	Drop(name string) error
 	CloseAll() error
 }
 
+// Errors returned by the error variants of TargetDb, use errors.Is to check for them.
+var (
+	ErrContainerStart = errors.New("container failed to start")
+	ErrDbCreate       = errors.New("database create failed")
+	ErrConnection     = errors.New("connection failed")
+	ErrUnknownDbType  = errors.New("unknown db type")
+)
+
 const (
 	LocalSqliteEnv = "LOCAL_SQLITE"
 	RunAllDBsEnv   = "TESTDBS_ALL"
-	defaultDbName  = "testdbDefault"
+	SelectDBsEnv   = "TESTDBS"
+	defaultDbName  = "testdbdefault"
 )
 
-func InitDBS() {
+// InitDBS initializes the default DBs, the options configure the gorm logger
+func InitDBS(opts ...LogOption) {
+	if err := InitDBSE(opts...); err != nil {
+		panic(err)
+	}
+}
+
+// InitDBSE is like InitDBS but returns an error instead of panicking,
+// DBs initialized before the failure are still cleaned up by Clean.
+func InitDBSE(opts ...LogOption) error {
 	fast := []TargetDb{&SqliteNoCgo{}}
 	long := []TargetDb{
 		&SqliteCgo{},
-		&testDBMysql{},
-		&testDBPostgres{},
+		&SqliteNoCgoMemory{},
+		&SqliteCgoMemory{},
+		NewMysql(),
+		NewMariadb(),
+		NewPostgres(),
 	}
-	InitCustomDbs(fast, long)
+	return InitCustomDbsE(fast, long, opts...)
 }
 
-func InitCustomDbs(fastDbs, longDBs []TargetDb) {
+// InitCustomDbs initializes the given DBs, the options configure the gorm logger. The envs TESTDBS_LOG_LEVEL,
+// TESTDBS_SLOW_THRESHOLD, TESTDBS_LOG_COLOR and TESTDBS_LOG_PER_TEST take precedence over the options.
+func InitCustomDbs(fastDbs, longDBs []TargetDb, opts ...LogOption) {
+	if err := InitCustomDbsE(fastDbs, longDBs, opts...); err != nil {
+		panic(err)
+	}
+}
 
-	gormLogger := logger.New(
-		log.New(os.Stdout, "\r\n", log.LstdFlags),
-		logger.Config{
-			SlowThreshold:             time.Second,
-			LogLevel:                  logger.Warn,
-			IgnoreRecordNotFoundError: true,
-			Colorful:                  false,
-		},
-	)
+// InitCustomDbsE is like InitCustomDbs but returns an error instead of panicking,
+// DBs initialized before the failure are still cleaned up by Clean.
+func InitCustomDbsE(fastDbs, longDBs []TargetDb, opts ...LogOption) error {
+	cfg, err := newLogConfig(opts...)
+	if err != nil {
+		return err
+	}
+	gormLogger := cfg.stdoutLogger()
 
 	flag.Parse()
 
-	allDbs := false
-	_, testAllEnv := os.LookupEnv(RunAllDBsEnv)
-	if testAllEnv || testAll() {
-		allDbs = true
+	dbs, err := selectDbs(fastDbs, longDBs)
+	if err != nil {
+		return err
 	}
 
-	dbs := fastDbs
-
-	// also run slow DBs
-	if allDbs {
-		for _, db := range longDBs {
-			if !slices.Contains(dbs, db) {
-				dbs = append(dbs, db)
-			}
+	dbTypes := map[string]bool{}
+	for _, db := range dbs {
+		if dbTypes[db.DbType()] {
+			return fmt.Errorf("duplicated db type %s, use WithLabel to tell apart DBs of the same engine", db.DbType())
 		}
+		dbTypes[db.DbType()] = true
 	}
 
+	logCfg = cfg
 	for _, db := range dbs {
-		db.Init(gormLogger)
+		if err := db.InitE(gormLogger); err != nil {
+			return fmt.Errorf("unable to initialize %s: %w", db.DbType(), err)
+		}
 		targetDBS = append(targetDBS, db)
 	}
+	return nil
+}
+
+// selectDbs returns the DBs to run the tests on, if a selection is passed with the flag or env
+// only the DBs matching it are returned, otherwise the fast DBs and, if requested, the long ones.
+func selectDbs(fastDbs, longDBs []TargetDb) ([]TargetDb, error) {
+	available := slices.Clone(fastDbs)
+	for _, db := range longDBs {
+		if !slices.Contains(available, db) {
+			available = append(available, db)
+		}
+	}
 
+	selection := selectedDbTypes()
+	if len(selection) > 0 {
+		dbs := []TargetDb{}
+		for _, dbType := range selection {
+			idx := slices.IndexFunc(available, func(db TargetDb) bool {
+				return strings.EqualFold(db.DbType(), dbType)
+			})
+			if idx == -1 {
+				names := make([]string, 0, len(available))
+				for _, db := range available {
+					names = append(names, db.DbType())
+				}
+				return nil, fmt.Errorf("%w: %s, available types are: %s", ErrUnknownDbType, dbType, strings.Join(names, ","))
+			}
+			if !slices.Contains(dbs, available[idx]) {
+				dbs = append(dbs, available[idx])
+			}
+		}
+		return dbs, nil
+	}
+
+	_, testAllEnv := os.LookupEnv(RunAllDBsEnv)
+	if testAllEnv || testAll() {
+		return available, nil
+	}
+	return fastDbs, nil
 }
 
 var targetDBS = []TargetDb{}
@@ -100,8 +171,32 @@ func Clean() error {
 // Flag to run fast DBs or all DBs
 var runAllDbs *bool
 
+// Flag to select the DBs to run
+var selectDbsFlag *string
+
 func init() {
 	runAllDbs = flag.Bool("alldbs", false, "run the tests on all available DBs")
+	selectDbsFlag = flag.String("testdbs", "", "comma separated list of DB types to run the tests on, e.g. postgres,mysql")
+}
+
+// selectedDbTypes returns the DB types passed with the testdbs flag, or if not set, with the TESTDBS env
+func selectedDbTypes() []string {
+	if selectDbsFlag == nil {
+		panic("testing: selectedDbTypes called before Init")
+	}
+	selection := *selectDbsFlag
+	if selection == "" {
+		selection = os.Getenv(SelectDBsEnv)
+	}
+
+	dbTypes := []string{}
+	for _, dbType := range strings.Split(selection, ",") {
+		dbType = strings.TrimSpace(dbType)
+		if dbType != "" {
+			dbTypes = append(dbTypes, dbType)
+		}
+	}
+	return dbTypes
 }
 func testAll() bool {
 	if runAllDbs == nil {
@@ -1,17 +1,34 @@
 package testdbs_test
 
 import (
+	"errors"
 	"fmt"
 	"github.com/go-bumbu/testdbs"
+	"github.com/go-bumbu/testdbs/internal/tbtest"
 	"github.com/google/go-cmp/cmp"
 	"go.uber.org/goleak"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 )
 
 func TestMain(m *testing.M) {
-	testdbs.InitDBS()
+	// the same DBs as InitDBS but the in-memory sqlite also runs by default
+	testdbs.InitCustomDbs(
+		[]testdbs.TargetDb{&testdbs.SqliteNoCgo{}, &testdbs.SqliteNoCgoMemory{}},
+		[]testdbs.TargetDb{
+			&testdbs.SqliteCgo{},
+			&testdbs.SqliteCgoMemory{},
+			testdbs.NewMysql(),
+			testdbs.NewMariadb(),
+			testdbs.NewPostgres(),
+		},
+	)
 	// main block that runs tests
 	code := m.Run()
 	err := testdbs.Clean()
@@ -141,3 +158,693 @@ func TestStartDbNew(t *testing.T) {
 		}
 	})
 }
+
+func TestErrorVariants(t *testing.T) {
+	t.Run("conn", func(t *testing.T) {
+		for _, dbt := range testdbs.DBs() {
+			t.Run(dbt.DbType(), func(t *testing.T) {
+				db, err := dbt.ConnE()
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if db == nil {
+					t.Fatal("expected a db connection")
+				}
+
+				db2, err := dbt.ConnDbNameE("customErr")
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if db2 == nil {
+					t.Fatal("expected a db connection")
+				}
+			})
+		}
+	})
+
+	t.Run("connectionFailed", func(t *testing.T) {
+		dbt := &testdbs.SqliteNoCgo{}
+		err := dbt.InitE(logger.Discard)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err = dbt.ConnE()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// removes the temp dir, so new connections can't be opened anymore
+		err = dbt.CloseAll()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		_, err = dbt.ConnDbNameE("missingDir")
+		if !errors.Is(err, testdbs.ErrConnection) {
+			t.Errorf("expected ErrConnection, got: %v", err)
+		}
+	})
+
+	t.Run("sqlserverUser", func(t *testing.T) {
+		// the user is checked before starting the container
+		dbt := testdbs.NewSqlserver(testdbs.WithCredentials("app", "Testdbs_Passw0rd"))
+		if err := dbt.InitE(logger.Discard); err == nil {
+			t.Error("expected an error for a user other than sa")
+		}
+	})
+}
+
+func TestForTest(t *testing.T) {
+	for _, dbt := range testdbs.DBs() {
+		t.Run(dbt.DbType(), func(t *testing.T) {
+			t.Run("first", func(t *testing.T) {
+				db := testdbs.ForTest(t, dbt)
+				err := db.AutoMigrate(&Item{})
+				if err != nil {
+					t.Fatalf("error in automigrate: %s", err)
+				}
+				result := db.Create(&Item{Name: "Sample Item"})
+				if result.Error != nil {
+					t.Fatalf("Failed to create item: %v", result.Error)
+				}
+			})
+
+			t.Run("second", func(t *testing.T) {
+				db := testdbs.ForTest(t, dbt)
+				if db.Migrator().HasTable(&Item{}) {
+					t.Error("expected an empty database for every test")
+				}
+			})
+		})
+	}
+}
+
+func TestDrop(t *testing.T) {
+	for _, dbt := range testdbs.DBs() {
+		t.Run(dbt.DbType(), func(t *testing.T) {
+			name := "dropped"
+			db, err := dbt.ConnDbNameE(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = db.AutoMigrate(&Item{})
+			if err != nil {
+				t.Fatalf("error in automigrate: %s", err)
+			}
+
+			err = dbt.Drop(name)
+			if err != nil {
+				t.Fatalf("unable to drop database: %v", err)
+			}
+
+			db, err = dbt.ConnDbNameE(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if db.Migrator().HasTable(&Item{}) {
+				t.Error("expected an empty database after drop")
+			}
+			err = dbt.Drop(name)
+			if err != nil {
+				t.Fatalf("unable to drop database: %v", err)
+			}
+		})
+	}
+}
+
+func TestSqliteClose(t *testing.T) {
+	dbt := &testdbs.SqliteNoCgo{}
+	err := dbt.InitE(logger.Discard)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() {
+		if err := dbt.CloseAll(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}()
+
+	for _, name := range []string{"first", "second"} {
+		db, err := dbt.ConnDbNameE(name)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := db.AutoMigrate(&Item{}); err != nil {
+			t.Fatalf("error in automigrate: %s", err)
+		}
+	}
+
+	err = dbt.Close("first")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := dbt.Close("first"); err == nil {
+		t.Error("expected an error closing a db that is not open")
+	}
+
+	// the other databases are still usable
+	result := dbt.ConnDbName("second").Create(&Item{Name: "Sample Item"})
+	if result.Error != nil {
+		t.Fatalf("Failed to create item: %v", result.Error)
+	}
+
+	db, err := dbt.ConnDbNameE("first")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if db.Migrator().HasTable(&Item{}) {
+		t.Error("expected the file of the closed database to be deleted")
+	}
+}
+
+func TestSqliteMemory(t *testing.T) {
+	for _, dbt := range []testdbs.TargetDb{&testdbs.SqliteNoCgoMemory{}, &testdbs.SqliteCgoMemory{}} {
+		t.Run(dbt.DbType(), func(t *testing.T) {
+			err := dbt.InitE(logger.Discard)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer func() {
+				if err := dbt.CloseAll(); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			}()
+
+			first, err := dbt.ConnDbNameE("first")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := first.AutoMigrate(&Item{}); err != nil {
+				t.Fatalf("error in automigrate: %s", err)
+			}
+			if err := first.Create(&Item{Name: "Sample Item"}).Error; err != nil {
+				t.Fatalf("Failed to create item: %v", err)
+			}
+
+			// the databases of different names are isolated
+			second, err := dbt.ConnDbNameE("second")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if second.Migrator().HasTable(&Item{}) {
+				t.Error("expected the table to only exist in the first database")
+			}
+
+			// the data persists across connections to the same name
+			var count int64
+			if err := dbt.ConnDbName("first").Model(&Item{}).Count(&count).Error; err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if count != 1 {
+				t.Errorf("expected 1 item, got %d", count)
+			}
+
+			if err := dbt.Drop("first"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			db, err := dbt.ConnDbNameE("first")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if db.Migrator().HasTable(&Item{}) {
+				t.Error("expected the dropped database to be discarded")
+			}
+		})
+	}
+}
+
+func TestKeepFailed(t *testing.T) {
+	t.Setenv(testdbs.KeepFailedEnv, "true")
+	dbt := &testdbs.SqliteNoCgo{}
+	err := dbt.InitE(logger.Discard)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() {
+		if err := dbt.CloseAll(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}()
+
+	for _, failing := range []bool{true, false} {
+		var tb *tbtest.TB
+		var dbFile string
+		t.Run(fmt.Sprintf("failing=%t", failing), func(t *testing.T) {
+			tb = tbtest.New(t)
+			tb.Failing = failing
+			db := testdbs.ForTest(tb, dbt)
+			if err := db.AutoMigrate(&Item{}); err != nil {
+				t.Fatalf("error in automigrate: %s", err)
+			}
+			if err := db.Raw("SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&dbFile).Error; err != nil {
+				t.Fatal(err)
+			}
+		})
+		// the cleanup of ForTest ran at the end of the subtest
+
+		if len(tb.Errors) != 0 {
+			t.Fatalf("unexpected errors: %v", tb.Errors)
+		}
+		if _, err := os.Stat(dbFile); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected the db file %s to be removed, got: %v", dbFile, err)
+		}
+		if !failing {
+			if len(tb.Logs) != 0 {
+				t.Errorf("expected no logs for a passing test, got %v", tb.Logs)
+			}
+			continue
+		}
+
+		if len(tb.Logs) != 1 {
+			t.Fatalf("expected the kept path in the logs, got %v", tb.Logs)
+		}
+		_, path, ok := strings.Cut(tb.Logs[0], "was kept in ")
+		if !ok {
+			t.Fatalf("expected the kept path in the log, got: %s", tb.Logs[0])
+		}
+		t.Cleanup(func() {
+			_ = os.Remove(path)
+			// only removed if no other kept databases are in it
+			_ = os.Remove(filepath.Dir(path))
+		})
+		if filepath.Base(filepath.Dir(path)) != "testdbs_failed" || filepath.Base(path) != filepath.Base(dbFile) {
+			t.Errorf("expected the db file %s in the dir testdbs_failed, got %s", filepath.Base(dbFile), path)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected the kept db file: %v", err)
+		}
+	}
+}
+
+func TestConcurrentConn(t *testing.T) {
+	for _, dbt := range testdbs.DBs() {
+		t.Run(dbt.DbType(), func(t *testing.T) {
+			for i := 0; i < 4; i++ {
+				t.Run(fmt.Sprintf("parallel%d", i), func(t *testing.T) {
+					t.Parallel()
+					name := fmt.Sprintf("concurrent%d", i%2)
+
+					const workers = 10
+					conns := make([]*gorm.DB, workers)
+					errs := make([]error, workers)
+					var wg sync.WaitGroup
+					for w := 0; w < workers; w++ {
+						wg.Add(1)
+						go func() {
+							defer wg.Done()
+							conns[w], errs[w] = dbt.ConnDbNameE(name)
+						}()
+					}
+					wg.Wait()
+
+					for w := 0; w < workers; w++ {
+						if errs[w] != nil {
+							t.Fatalf("unexpected error: %v", errs[w])
+						}
+						if conns[w] != conns[0] {
+							t.Errorf("expected all workers to get the same connection for %s", name)
+						}
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestConnFromTemplate(t *testing.T) {
+	for _, dbt := range testdbs.DBs() {
+		t.Run(dbt.DbType(), func(t *testing.T) {
+			tdb, ok := dbt.(testdbs.TemplateDb)
+			if !ok {
+				t.Skipf("%s does not support templates", dbt.DbType())
+			}
+
+			calls := 0
+			prepare := func(db *gorm.DB) error {
+				calls++
+				if err := db.AutoMigrate(&Item{}); err != nil {
+					return err
+				}
+				return db.Create(&Item{Name: "template item"}).Error
+			}
+			for i := 0; i < 2; i++ {
+				err := tdb.PrepareTemplate("itemsTemplate", prepare)
+				if errors.Is(err, errors.ErrUnsupported) {
+					t.Skipf("%s does not support templates", dbt.DbType())
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if calls != 1 {
+				t.Errorf("expected the template to be prepared once, got %d calls", calls)
+			}
+
+			db1, err := tdb.ConnFromTemplate("itemsTemplate", "fromTemplate1")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			db2, err := tdb.ConnFromTemplate("itemsTemplate", "fromTemplate2")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := db1.Create(&Item{Name: "only in copy 1"}).Error; err != nil {
+				t.Fatalf("Failed to create item: %v", err)
+			}
+
+			var count1, count2 int64
+			db1.Model(&Item{}).Count(&count1)
+			db2.Model(&Item{}).Count(&count2)
+			if count1 != 2 || count2 != 1 {
+				t.Errorf("expected copies to be isolated, got %d and %d items", count1, count2)
+			}
+
+			_, err = tdb.ConnFromTemplate("missingTemplate", "fromTemplate3")
+			if !errors.Is(err, testdbs.ErrDbCreate) {
+				t.Errorf("expected ErrDbCreate, got: %v", err)
+			}
+		})
+	}
+}
+
+type TxItem struct {
+	ID   uint `gorm:"primaryKey"`
+	Name string
+}
+
+func TestTxConn(t *testing.T) {
+	for _, dbt := range testdbs.DBs() {
+		t.Run(dbt.DbType(), func(t *testing.T) {
+			// DDL commits implicitly on mysql, so the table is created outside the transaction
+			err := dbt.Conn().AutoMigrate(&TxItem{})
+			if err != nil {
+				t.Fatalf("error in automigrate: %s", err)
+			}
+
+			t.Run("rollback", func(t *testing.T) {
+				db := testdbs.TxConn(t, dbt)
+				if err := db.Create(&TxItem{Name: "outer"}).Error; err != nil {
+					t.Fatalf("Failed to create item: %v", err)
+				}
+
+				// nested Begin and Commit
+				tx := db.Begin()
+				if err := tx.Create(&TxItem{Name: "committed"}).Error; err != nil {
+					t.Fatalf("Failed to create item: %v", err)
+				}
+				if err := tx.Commit().Error; err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				// nested Begin and Rollback
+				tx = db.Begin()
+				if err := tx.Create(&TxItem{Name: "rolled back"}).Error; err != nil {
+					t.Fatalf("Failed to create item: %v", err)
+				}
+				if err := tx.Rollback().Error; err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				// nested Transaction returning an error
+				_ = db.Transaction(func(tx *gorm.DB) error {
+					tx.Create(&TxItem{Name: "failed transaction"})
+					return errors.New("fail")
+				})
+
+				var count int64
+				db.Model(&TxItem{}).Count(&count)
+				if count != 2 {
+					t.Errorf("expected 2 items in the transaction, got %d", count)
+				}
+
+				if err := db.Commit().Error; !errors.Is(err, gorm.ErrInvalidTransaction) {
+					t.Errorf("expected ErrInvalidTransaction, got: %v", err)
+				}
+			})
+
+			var count int64
+			dbt.Conn().Model(&TxItem{}).Count(&count)
+			if count != 0 {
+				t.Errorf("expected the transaction to be rolled back, got %d items", count)
+			}
+		})
+	}
+}
+
+func TestVersionLabels(t *testing.T) {
+	dbs := append(testdbs.PostgresVersions("12", "16"), testdbs.MysqlVersions("8.4")...)
+	dbs = append(dbs, testdbs.MariadbVersions("10.11")...)
+	dbs = append(dbs, testdbs.NewMariadb(), testdbs.NewCockroachdb(), testdbs.NewSqlserver())
+	want := []string{"postgres-12", "postgres-16", "mysql-8.4", "mariadb-10.11", "mariadb", "cockroachdb", "sqlserver"}
+	got := []string{}
+	for _, db := range dbs {
+		got = append(got, db.DbType())
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Mismatch (-want +got):\n%s", diff)
+	}
+}
+
+type SchemaUser struct {
+	ID    uint   `gorm:"primaryKey"`
+	Email string `gorm:"size:100;uniqueIndex:idx_schema_users_email;not null"`
+	Name  string `gorm:"index:idx_schema_users_name"`
+}
+
+type SchemaPost struct {
+	ID           uint `gorm:"primaryKey"`
+	SchemaUserID uint
+	SchemaUser   SchemaUser
+	Title        *string
+}
+
+func TestInspect(t *testing.T) {
+	for _, dbt := range testdbs.DBs() {
+		t.Run(dbt.DbType(), func(t *testing.T) {
+			name := "inspect"
+			db, err := dbt.ConnDbNameE(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				if err := dbt.Drop(name); err != nil {
+					t.Errorf("unable to drop database: %v", err)
+				}
+			})
+			if err := db.AutoMigrate(&SchemaUser{}, &SchemaPost{}); err != nil {
+				t.Fatalf("error in automigrate: %s", err)
+			}
+
+			schema, err := testdbs.Inspect(dbt, name)
+			if err != nil {
+				t.Fatalf("unable to inspect schema: %v", err)
+			}
+			users, ok := schema.Table("schema_users")
+			if !ok {
+				t.Fatalf("expected table schema_users, got %v", schema.Tables)
+			}
+			// sqlite has no varchar, gorm uses text for all strings
+			emailType := "varchar(100)"
+			if db.Dialector.Name() == "sqlite" {
+				emailType = "text"
+			}
+			wantColumns := []testdbs.Column{
+				{Name: "id", Type: users.Columns[0].Type},
+				{Name: "email", Type: emailType},
+				{Name: "name", Type: "text", Nullable: true},
+			}
+			if diff := cmp.Diff(wantColumns, users.Columns); diff != "" {
+				t.Errorf("unexpected columns (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff([]string{"id"}, users.PrimaryKey); diff != "" {
+				t.Errorf("unexpected primary key (-want +got):\n%s", diff)
+			}
+			wantIndexes := []testdbs.Index{
+				{Name: "idx_schema_users_email", Columns: []string{"email"}, Unique: true},
+				{Name: "idx_schema_users_name", Columns: []string{"name"}},
+			}
+			if diff := cmp.Diff(wantIndexes, users.Indexes); diff != "" {
+				t.Errorf("unexpected indexes (-want +got):\n%s", diff)
+			}
+
+			posts, ok := schema.Table("schema_posts")
+			if !ok {
+				t.Fatalf("expected table schema_posts, got %v", schema.Tables)
+			}
+			wantFks := []testdbs.ForeignKey{
+				{Columns: []string{"schema_user_id"}, ReferencedTable: "schema_users", ReferencedColumns: []string{"id"}},
+			}
+			if diff := cmp.Diff(wantFks, posts.ForeignKeys); diff != "" {
+				t.Errorf("unexpected foreign keys (-want +got):\n%s", diff)
+			}
+
+			if diffs := testdbs.Diff(schema, schema); len(diffs) != 0 {
+				t.Errorf("expected no differences, got %v", diffs)
+			}
+			if err := db.Migrator().DropIndex(&SchemaUser{}, "idx_schema_users_name"); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Migrator().DropTable(&SchemaPost{}); err != nil {
+				t.Fatal(err)
+			}
+			got, err := testdbs.InspectConn(db)
+			if err != nil {
+				t.Fatalf("unable to inspect schema: %v", err)
+			}
+			wantDiffs := []string{
+				"table schema_posts is missing",
+				"table schema_users: index idx_schema_users_name is missing",
+			}
+			if diff := cmp.Diff(wantDiffs, testdbs.Diff(schema, got)); diff != "" {
+				t.Errorf("unexpected differences (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestInspectSqliteImplicitReference(t *testing.T) {
+	for _, dbt := range testdbs.DBs() {
+		t.Run(dbt.DbType(), func(t *testing.T) {
+			db := testdbs.ForTest(t, dbt)
+			if db.Dialector.Name() != "sqlite" {
+				t.Skip("only sqlite allows leaving out the referenced columns")
+			}
+			// the foreign keys don't name the referenced columns, they reference the primary key
+			statements := []string{
+				"CREATE TABLE a (id INTEGER PRIMARY KEY)",
+				"CREATE TABLE b (x INTEGER, y INTEGER, PRIMARY KEY (x, y))",
+				"CREATE TABLE c (id INTEGER PRIMARY KEY, first_id INTEGER REFERENCES a, second_id INTEGER REFERENCES a, " +
+					"bx INTEGER, by INTEGER, FOREIGN KEY (bx, by) REFERENCES b)",
+			}
+			for _, stmt := range statements {
+				if err := db.Exec(stmt).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			schema, err := testdbs.InspectConn(db)
+			if err != nil {
+				t.Fatalf("unable to inspect schema: %v", err)
+			}
+			c, ok := schema.Table("c")
+			if !ok {
+				t.Fatalf("expected table c, got %v", schema.Tables)
+			}
+			wantFks := []testdbs.ForeignKey{
+				{Columns: []string{"bx", "by"}, ReferencedTable: "b", ReferencedColumns: []string{"x", "y"}},
+				{Columns: []string{"first_id"}, ReferencedTable: "a", ReferencedColumns: []string{"id"}},
+				{Columns: []string{"second_id"}, ReferencedTable: "a", ReferencedColumns: []string{"id"}},
+			}
+			if diff := cmp.Diff(wantFks, c.ForeignKeys); diff != "" {
+				t.Errorf("unexpected foreign keys (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRecord(t *testing.T) {
+	for _, dbt := range testdbs.DBs() {
+		t.Run(dbt.DbType(), func(t *testing.T) {
+			db := testdbs.ForTest(t, dbt)
+			if err := db.AutoMigrate(&Item{}); err != nil {
+				t.Fatalf("error in automigrate: %s", err)
+			}
+			items := []Item{{Name: "first"}, {Name: "second"}}
+			if err := db.Create(&items).Error; err != nil {
+				t.Fatalf("Failed to create items: %v", err)
+			}
+
+			recDb, rec := testdbs.Record(db)
+			var got []Item
+			if err := recDb.Find(&got).Error; err != nil {
+				t.Fatal(err)
+			}
+			var item Item
+			if err := recDb.Where("name = ?", "second").First(&item).Error; err != nil {
+				t.Fatal(err)
+			}
+			// the statements of the original connection are not recorded
+			db.Find(&got)
+
+			rec.ExpectQueryCount(t, 2)
+			rec.ExpectNoQueryMatching(t, `(?i)^(INSERT|UPDATE|DELETE)`)
+			queries := rec.Queries()
+			if len(queries) != 2 {
+				t.Fatalf("expected 2 queries, got %d", len(queries))
+			}
+			if diff := cmp.Diff([]any{"second"}, queries[1].Vars[:1]); diff != "" {
+				t.Errorf("unexpected vars (-want +got):\n%s", diff)
+			}
+			if queries[0].RowsAffected != 2 {
+				t.Errorf("expected 2 rows affected, got %d", queries[0].RowsAffected)
+			}
+			if !strings.Contains(queries[0].Caller, "testdbs_test.go") {
+				t.Errorf("expected the caller in the test file, got %s", queries[0].Caller)
+			}
+
+			tb := tbtest.New(t)
+			rec.ExpectQueryCount(tb, 1)
+			rec.ExpectNoQueryMatching(tb, `(?i)^SELECT`)
+			if len(tb.Errors) != 2 {
+				t.Errorf("expected 2 failed assertions, got %v", tb.Errors)
+			}
+
+			rec.Reset()
+			rec.ExpectQueryCount(t, 0)
+		})
+	}
+}
+
+type Tag struct {
+	ID    uint `gorm:"primaryKey"`
+	Label string
+}
+
+func TestRecordReuse(t *testing.T) {
+	for _, dbt := range testdbs.DBs() {
+		t.Run(dbt.DbType(), func(t *testing.T) {
+			db := testdbs.ForTest(t, dbt)
+			if err := db.AutoMigrate(&Item{}, &Tag{}); err != nil {
+				t.Fatalf("error in automigrate: %s", err)
+			}
+			if err := db.Create(&[]Item{{Name: "first"}, {Name: "second"}}).Error; err != nil {
+				t.Fatalf("Failed to create items: %v", err)
+			}
+			if err := db.Create(&[]Tag{{Label: "a"}, {Label: "b"}, {Label: "c"}}).Error; err != nil {
+				t.Fatalf("Failed to create tags: %v", err)
+			}
+
+			// the same recorded db is used for a filtered query and then plain queries on other models
+			recDb, rec := testdbs.Record(db)
+			var item Item
+			if err := recDb.Where("name = ?", "second").First(&item).Error; err != nil {
+				t.Fatal(err)
+			}
+			var items []Item
+			if err := recDb.Find(&items).Error; err != nil {
+				t.Fatal(err)
+			}
+			var tags []Tag
+			if err := recDb.Find(&tags).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			if len(items) != 2 || len(tags) != 3 {
+				t.Errorf("expected 2 items and 3 tags, got %d and %d", len(items), len(tags))
+			}
+			rec.ExpectQueryCount(t, 3)
+			queries := rec.Queries()
+			if len(queries) != 3 {
+				t.Fatalf("expected 3 queries, got %d", len(queries))
+			}
+			for _, q := range queries[1:] {
+				if strings.Contains(strings.ToUpper(q.SQL), "WHERE") || len(q.Vars) != 0 {
+					t.Errorf("expected a query without conditions, got %s", q)
+				}
+			}
+			if !strings.Contains(queries[2].SQL, "tags") {
+				t.Errorf("expected a query on the tags, got %s", queries[2].SQL)
+			}
+		})
+	}
+}
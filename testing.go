@@ -0,0 +1,82 @@
+package testdbs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"hash/fnv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// ForTest creates a database dedicated to the running test and returns a connection to it.
+// The database is closed and dropped once the test and all its subtests complete,
+// setup failures are reported with t.Fatalf.
+// With the keepfailed flag or the TESTDBS_KEEP_FAILED env, the database of a failed test is saved
+// to the dir testdbs_failed before being dropped.
+// With WithTestLog or the TESTDBS_LOG_PER_TEST env, the statements are logged with t.Logf.
+func ForTest(t testing.TB, dbt TargetDb) *gorm.DB {
+	t.Helper()
+	name := testDbName(t.Name())
+	db, err := dbt.ConnDbNameE(name)
+	if err != nil {
+		t.Fatalf("unable to create database %s on %s: %v", name, dbt.DbType(), err)
+	}
+	t.Cleanup(func() {
+		if t.Failed() && keepFailed() {
+			keepDb(t, dbt, name)
+		}
+		if err := dbt.Drop(name); err != nil {
+			t.Errorf("unable to drop database %s on %s: %v", name, dbt.DbType(), err)
+		}
+	})
+	return testLog(t, db)
+}
+
+// TxConn returns a connection to the default database of the TargetDb that runs everything in a transaction,
+// the transaction is rolled back once the test and all its subtests complete.
+// Nested transactions started by the code under test with Begin or Transaction use savepoints.
+// The connection must not be used concurrently, and statements that commit implicitly,
+// like DDL on mysql, end the transaction. Like ForTest, the statements can be logged with t.Logf.
+func TxConn(t testing.TB, dbt TargetDb) *gorm.DB {
+	t.Helper()
+	db, err := dbt.ConnE()
+	if err != nil {
+		t.Fatalf("unable to connect to %s: %v", dbt.DbType(), err)
+	}
+	sqlDb, err := db.DB()
+	if err != nil {
+		t.Fatalf("unable to get underlying DB of %s: %v", dbt.DbType(), err)
+	}
+
+	ctx := context.Background()
+	tx, err := sqlDb.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("unable to begin transaction on %s: %v", dbt.DbType(), err)
+	}
+	t.Cleanup(func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			t.Errorf("unable to rollback transaction on %s: %v", dbt.DbType(), err)
+		}
+	})
+
+	txDb := db.Session(&gorm.Session{NewDB: true, Context: ctx})
+	txDb.Statement.ConnPool = &txPool{Tx: tx, seq: &atomic.Int64{}, dialect: db.Dialector.Name()}
+	return testLog(t, txDb)
+}
+
+// testDbName derives a database name from a test name, the name is shortened to stay within
+// the identifier limits of all DBs and a hash of the full name is appended to keep it unique.
+func testDbName(testName string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(testName))
+
+	name := strings.ReplaceAll(normalizeDbName(testName), "-", "_")
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	return fmt.Sprintf("%s_%08x", name, h.Sum32())
+}
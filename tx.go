@@ -0,0 +1,59 @@
+package testdbs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"gorm.io/gorm"
+	"sync/atomic"
+)
+
+// txPool is the gorm.ConnPool used by TxConn, it runs every statement in the same transaction
+// and turns nested Begin, Commit and Rollback calls into savepoints.
+type txPool struct {
+	*sql.Tx
+	// savepoint is empty for the outer transaction
+	savepoint string
+	seq       *atomic.Int64
+	// dialect is the name of the gorm dialector, sql server uses its own savepoint statements
+	dialect string
+}
+
+// BeginTx creates a savepoint instead of a new transaction
+func (p *txPool) BeginTx(ctx context.Context, _ *sql.TxOptions) (gorm.ConnPool, error) {
+	name := fmt.Sprintf("testdbs_sp%d", p.seq.Add(1))
+	query := "SAVEPOINT " + name
+	if p.dialect == "sqlserver" {
+		query = "SAVE TRANSACTION " + name
+	}
+	if _, err := p.Tx.ExecContext(ctx, query); err != nil {
+		return nil, err
+	}
+	return &txPool{Tx: p.Tx, savepoint: name, seq: p.seq, dialect: p.dialect}, nil
+}
+
+// Commit releases the savepoint, the outer transaction can't be committed
+func (p *txPool) Commit() error {
+	if p.savepoint == "" {
+		return fmt.Errorf("%w: the transaction of TxConn is rolled back once the test completes", gorm.ErrInvalidTransaction)
+	}
+	// sql server can't release savepoints, they are kept until the transaction ends
+	if p.dialect == "sqlserver" {
+		return nil
+	}
+	_, err := p.Tx.Exec("RELEASE SAVEPOINT " + p.savepoint)
+	return err
+}
+
+// Rollback rolls back to the savepoint, the outer transaction is only rolled back once the test completes
+func (p *txPool) Rollback() error {
+	if p.savepoint == "" {
+		return fmt.Errorf("%w: the transaction of TxConn is rolled back once the test completes", gorm.ErrInvalidTransaction)
+	}
+	query := "ROLLBACK TO SAVEPOINT " + p.savepoint
+	if p.dialect == "sqlserver" {
+		query = "ROLLBACK TRANSACTION " + p.savepoint
+	}
+	_, err := p.Tx.Exec(query)
+	return err
+}